@@ -0,0 +1,225 @@
+package main
+
+import (
+	"compress/gzip"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/haskelladdict/mbdr/libmbd"
+	"github.com/haskelladdict/mbdr/parser"
+)
+
+// compressors lists the compression formats supported for writing output
+// files. Other formats, e.g. bzip2 or xz, can only be read.
+var compressors = map[string]func(io.Writer) io.WriteCloser{
+	"none": func(w io.Writer) io.WriteCloser { return nopWriteCloser{w} },
+	"gzip": func(w io.Writer) io.WriteCloser { return gzip.NewWriter(w) },
+}
+
+// nopWriteCloser turns an io.Writer into an io.WriteCloser
+type nopWriteCloser struct {
+	io.Writer
+}
+
+// Close is a no-op
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+// runConvert converts a binary mcell file of any supported API version into an
+// MCELL_BINARY_API_2 file with the requested compression and output buffer
+// size and then verifies that all data blocks decode to the original values
+func runConvert(args []string) error {
+	flags := flag.NewFlagSet("convert", flag.ExitOnError)
+	compression := flags.String("c", "", "compression of output file (none, gzip; "+
+		"defaults to the compression of the input file)")
+	bufSize := flags.Uint64("b", 0, "number of rows per stream block (0 keeps the "+
+		"original chunk size)")
+	verify := flags.Bool("verify", true, "verify converted data against the original")
+	flags.Usage = func() {
+		fmt.Println("usage: mbdr convert [options] <input file> <output file>")
+		fmt.Println("\noptions:")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 2 {
+		flags.Usage()
+		return fmt.Errorf("convert requires an input and an output file")
+	}
+	inName, outName := flags.Arg(0), flags.Arg(1)
+
+	compressor, err := outputCompressor(inName, *compression)
+	if err != nil {
+		return err
+	}
+	if outName == "-" && *verify {
+		return fmt.Errorf("verification requires an output file, use -verify=false " +
+			"to write to stdout")
+	}
+
+	data, err := read(inName)
+	if err != nil {
+		return err
+	}
+	defer data.Close()
+
+	blocks, err := data.DataBlocks()
+	if err != nil {
+		return err
+	}
+	size := *bufSize
+	if size == 0 {
+		size = data.OutputBufSize()
+	}
+	if size == 0 {
+		size = libmbd.DefaultOutputBufSize
+	}
+
+	if err := writeAPI2(outName, compressor, blocks, data.TimeSpec(), size); err != nil {
+		return err
+	}
+
+	if *verify {
+		return verifyConversion(data, blocks, outName)
+	}
+	return nil
+}
+
+// outputCompressor returns the compressor for the requested compression
+// format. Without a requested format the compression of the named input file
+// is kept. Formats which can be read but not written are rejected so that
+// converted files never silently change their compression.
+func outputCompressor(inName, compression string) (func(io.Writer) io.WriteCloser,
+	error) {
+
+	if compression == "" {
+		if inName == "-" {
+			return nil, fmt.Errorf("converting stdin requires choosing the output " +
+				"compression via -c")
+		}
+		var err error
+		if compression, err = parser.Compression(inName); err != nil {
+			return nil, err
+		}
+	}
+
+	compressor, ok := compressors[compression]
+	if !ok {
+		var names []string
+		for n := range compressors {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("writing %s compressed files is not supported (supported "+
+			"formats: %s), choose one via -c", compression, strings.Join(names, ", "))
+	}
+	return compressor, nil
+}
+
+// writeAPI2 writes the provided data blocks as MCELL_BINARY_API_2 file to the
+// named output file (or stdout for "-") using the requested compressor. The
+// metadata of the data blocks (if any) are written to a sidecar file.
+func writeAPI2(filename string, compressor func(io.Writer) io.WriteCloser,
+	blocks []libmbd.DataBlock, spec libmbd.TimeSpec, bufSize uint64) error {
+
+	output := os.Stdout
+	if filename != "-" {
+		var err error
+		if output, err = os.Create(filename); err != nil {
+			return err
+		}
+		defer output.Close()
+	}
+
+	w := compressor(output)
+	if err := libmbd.WriteAPI2(w, blocks, spec, bufSize); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	if filename == "-" {
+		return nil
+	}
+	if err := output.Sync(); err != nil {
+		return err
+	}
+
+	// binary files can't hold metadata, they are kept in a sidecar instead
+	meta := make(map[string]libmbd.BlockMeta)
+	for _, b := range blocks {
+		if !b.Data.Meta.Empty() {
+			meta[b.Name] = b.Data.Meta
+		}
+	}
+	if len(meta) != 0 {
+		return parser.WriteMeta(filename+parser.MetaSuffix, meta)
+	}
+	return nil
+}
+
+// verifyConversion checks that the converted file contains the same data
+// blocks, data types, output times, and values as the original data
+func verifyConversion(orig *libmbd.MCellData, blocks []libmbd.DataBlock,
+	filename string) error {
+
+	conv, err := parser.Read(filename)
+	if err != nil {
+		return fmt.Errorf("verification of %s failed: %s", filename, err)
+	}
+	defer conv.Close()
+
+	if conv.NumDataBlocks() != uint64(len(blocks)) {
+		return fmt.Errorf("verification of %s failed: expected %d data blocks but "+
+			"found %d", filename, len(blocks), conv.NumDataBlocks())
+	}
+	if !equalFloats(orig.OutputTimes(), conv.OutputTimes()) {
+		return fmt.Errorf("verification of %s failed: output times differ", filename)
+	}
+
+	for id, b := range blocks {
+		name, err := conv.IDtoBlockName(uint64(id))
+		if err != nil {
+			return err
+		}
+		if name != b.Name {
+			return fmt.Errorf("verification of %s failed: data block %d is named %s "+
+				"instead of %s", filename, id, name, b.Name)
+		}
+
+		countData, err := conv.BlockDataByID(uint64(id))
+		if err != nil {
+			return fmt.Errorf("verification of %s failed: %s", filename, err)
+		}
+		if len(countData.Col) != len(b.Data.Col) {
+			return fmt.Errorf("verification of %s failed: data block %s has %d "+
+				"instead of %d columns", filename, name, len(countData.Col), len(b.Data.Col))
+		}
+		for c := range b.Data.Col {
+			if countData.DataTypes[c] != b.Data.DataTypes[c] ||
+				!equalFloats(countData.Col[c], b.Data.Col[c]) {
+				return fmt.Errorf("verification of %s failed: column %d of data block "+
+					"%s differs", filename, c, name)
+			}
+		}
+	}
+	return nil
+}
+
+// equalFloats tests if the two slices contain bitwise identical values
+func equalFloats(a, b []float64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if math.Float64bits(a[i]) != math.Float64bits(b[i]) {
+			return false
+		}
+	}
+	return true
+}
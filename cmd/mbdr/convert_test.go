@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/haskelladdict/mbdr/libmbd"
+	"github.com/haskelladdict/mbdr/parser"
+)
+
+// TestConvertCompression checks that convert keeps the compression of the
+// input file by default and rejects formats which can't be written
+func TestConvertCompression(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mbdr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	blocks := []libmbd.DataBlock{{Name: "a", Data: &libmbd.CountData{
+		Col: [][]float64{{1, 2, 3}}, DataTypes: []uint16{libmbd.IntType}}}}
+	spec := libmbd.TimeSpec{OutputListType: libmbd.Step, StepSize: 1e-6}
+	if err := libmbd.WriteAPI2(w, blocks, spec, 2); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	gzName := filepath.Join(dir, "a.bin.gz")
+	if err := ioutil.WriteFile(gzName, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	outName := filepath.Join(dir, "b.bin.gz")
+	if err := runConvert([]string{gzName, outName}); err != nil {
+		t.Fatal(err)
+	}
+	if c, err := parser.Compression(outName); err != nil || c != "gzip" {
+		t.Errorf("got compression %q (%v), want gzip", c, err)
+	}
+
+	bzName := "../../parser/testdata/counts.bin.bz2"
+	tests := []struct {
+		name        string
+		args        []string
+		wantMissing string
+	}{
+		{"bzip2 default", []string{bzName, filepath.Join(dir, "c.bin")}, "bzip2"},
+		{"xz", []string{"-c", "xz", gzName, filepath.Join(dir, "d.bin")}, "xz"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := runConvert(tt.args)
+			if err == nil || !strings.Contains(err.Error(), tt.wantMissing) ||
+				!strings.Contains(err.Error(), "gzip") {
+				t.Errorf("got error %v, want rejection of %s", err, tt.wantMissing)
+			}
+		})
+	}
+}
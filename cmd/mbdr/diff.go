@@ -0,0 +1,152 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"strings"
+
+	"github.com/haskelladdict/mbdr/libmbd"
+)
+
+// runDiff compares the data blocks of two binary mcell files by name and
+// reports missing and extra data blocks, differences of the output times and
+// column layout as well as numeric differences of each data block. An error
+// is returned if the files differ.
+func runDiff(args []string) error {
+	flags := flag.NewFlagSet("diff", flag.ExitOnError)
+	regex := flags.String("R", "", "regular expression of dataset(s) to compare "+
+		"(default all)")
+	absTol := flags.Float64("abs", 0, "absolute tolerance")
+	relTol := flags.Float64("rel", 0, "relative tolerance")
+	jsonFlag := flags.Bool("json", false, "write the report as JSON")
+	quiet := flags.Bool("q", false, "only report differing datasets")
+	flags.Float64Var(&resampleFlag, "resample", 0, "compare on a time grid with the "+
+		"given spacing covering\n\tthe output times common to both files")
+	flags.StringVar(&methodFlag, "method", "hold", "resampling method (hold, linear, "+
+		"average)")
+	flags.BoolVar(&lazyFlag, "L", false, "only decode the compared dataset(s) to keep "+
+		"memory use small\n\t(requires uncompressed or bzip2 compressed files)")
+	flags.Usage = func() {
+		fmt.Println("usage: mbdr diff [options] <file a> <file b>")
+		fmt.Println("\nValues a and b are equal if |a - b| <= abs + rel * max(|a|, |b|).")
+		fmt.Println("\noptions:")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 2 {
+		flags.Usage()
+		return fmt.Errorf("diff requires two files")
+	}
+
+	a, err := read(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer a.Close()
+	b, err := read(flags.Arg(1))
+	if err != nil {
+		return err
+	}
+	defer b.Close()
+
+	opts := libmbd.DiffOptions{Selection: *regex, AbsTol: *absTol, RelTol: *relTol}
+	if resampleFlag > 0 {
+		if opts.Grid, opts.Method, err = commonGrid(a, b); err != nil {
+			return err
+		}
+	}
+	report, err := libmbd.Diff(a, b, opts)
+	if err != nil {
+		return err
+	}
+
+	if *jsonFlag {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(report); err != nil {
+			return err
+		}
+	} else {
+		showDiff(report, *quiet)
+	}
+
+	if !report.Equal() {
+		return fmt.Errorf("%s and %s differ", flags.Arg(0), flags.Arg(1))
+	}
+	return nil
+}
+
+// commonGrid returns the time grid with the spacing requested via -resample
+// covering the output times common to a and b as well as the resampling
+// method requested via -method
+func commonGrid(a, b *libmbd.MCellData) ([]float64, libmbd.ResampleMethod, error) {
+	gridA, method, err := resampleGrid(a)
+	if err != nil {
+		return nil, method, err
+	}
+	gridB, _, err := resampleGrid(b)
+	if err != nil {
+		return nil, method, err
+	}
+	start := math.Max(gridA[0], gridB[0])
+	end := math.Min(gridA[len(gridA)-1], gridB[len(gridB)-1])
+	grid, err := libmbd.UniformGrid(start, end, resampleFlag)
+	if err != nil {
+		return nil, method, fmt.Errorf("output times of %s and %s don't overlap",
+			a.FileName(), b.FileName())
+	}
+	return grid, method, nil
+}
+
+// showDiff prints a human readable version of the diff report to stdout
+func showDiff(report *libmbd.DiffReport, quiet bool) {
+	fmt.Printf("--- %s\n+++ %s\n", report.FileA, report.FileB)
+	for _, n := range report.Missing {
+		fmt.Printf("- %s: missing in %s\n", n, report.FileB)
+	}
+	for _, n := range report.Extra {
+		fmt.Printf("+ %s: missing in %s\n", n, report.FileA)
+	}
+	for _, d := range report.TimeSpec {
+		fmt.Printf("! output times: %s\n", d)
+	}
+
+	for _, b := range report.Blocks {
+		if quiet && b.Equal() {
+			continue
+		}
+
+		var problems []string
+		if b.NumColsA != b.NumColsB {
+			problems = append(problems, fmt.Sprintf("%d vs %d columns", b.NumColsA,
+				b.NumColsB))
+		}
+		if len(b.TypeMismatches) != 0 {
+			var cols []string
+			for _, c := range b.TypeMismatches {
+				cols = append(cols, fmt.Sprint(c))
+			}
+			problems = append(problems, fmt.Sprintf("data types of column(s) %s differ",
+				strings.Join(cols, ", ")))
+		}
+		if !b.Compared {
+			problems = append(problems, "values not compared")
+		} else {
+			errs := fmt.Sprintf("max abs err %g, max rel err %g", b.MaxAbsErr, b.MaxRelErr)
+			if b.Diverged {
+				errs += fmt.Sprintf(", first diverging at time %g (row %d)", b.FirstTime,
+					b.FirstRow)
+			}
+			problems = append(problems, errs)
+		}
+
+		status := "="
+		if !b.Equal() {
+			status = "!"
+		}
+		fmt.Printf("%s %s: %s\n", status, b.Name, strings.Join(problems, "; "))
+	}
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/haskelladdict/mbdr/libmbd"
+)
+
+// ensembleSuffix is appended to the dataset names to form the names of the
+// files written by the ensemble command
+const ensembleSuffix = ".ensemble"
+
+// runEnsemble computes statistics of the selected data blocks across all
+// provided files (e.g. one file per seed). Each file is read and accumulated
+// in turn so only a single file is held in memory at a time. The statistics
+// are written as text or, if requested, the ensemble mean is written as
+// MCELL_BINARY_API_2 file.
+func runEnsemble(args []string) error {
+	flags := flag.NewFlagSet("ensemble", flag.ExitOnError)
+	regex := flags.String("R", "", "regular expression of dataset(s) to accumulate "+
+		"(default all)")
+	quantiles := flags.String("q", "", "comma separated list of quantiles to estimate, "+
+		"e.g. 0.05,0.5,0.95")
+	confidence := flags.Float64("ci", libmbd.DefaultConfidence, "confidence level of the "+
+		"intervals around the mean")
+	write := flags.Bool("w", false, "write each dataset to <name>"+ensembleSuffix)
+	outName := flags.String("o", "", "write the ensemble mean as MCELL_BINARY_API_2 "+
+		"file instead")
+	withStd := flags.Bool("std", false, "also write the standard deviation of each "+
+		"dataset as <name>"+libmbd.StdDevSuffix+"\n\t(requires -o)")
+	compression := flags.String("c", "gzip", "compression of the file written "+
+		"via -o (none, gzip)")
+	bufSize := flags.Uint64("b", libmbd.DefaultOutputBufSize, "number of rows per "+
+		"stream block of the file written via -o")
+	flags.BoolVar(&lazyFlag, "L", false, "only decode the selected dataset(s) to keep "+
+		"memory use small\n\t(requires uncompressed or bzip2 compressed files)")
+	flags.StringVar(&metaFile, "m", "", "metadata sidecar or MDL file providing column "+
+		"labels\n\t("+metaAuto+" uses the sidecar of each data file if present)")
+	flags.Float64Var(&resampleFlag, "resample", 0, "resample all files onto a time "+
+		"grid with the given spacing\n\tcovering the output times of the first file")
+	flags.StringVar(&methodFlag, "method", "hold", "resampling method (hold, linear, "+
+		"average)")
+	flags.Usage = func() {
+		fmt.Println("usage: mbdr ensemble [options] <binary mcell files>")
+		fmt.Println("\nFor each dataset and output time writes the mean, standard " +
+			"deviation,\nminimum, maximum, requested quantiles, and confidence interval " +
+			"of the mean\nacross all files.")
+		fmt.Println("\noptions:")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return fmt.Errorf("ensemble requires at least one file")
+	}
+
+	compressor, ok := compressors[*compression]
+	if !ok {
+		return fmt.Errorf("compression %s is not supported for writing", *compression)
+	}
+	if *bufSize == 0 {
+		return fmt.Errorf("number of rows per stream block has to be positive")
+	}
+	if *withStd && *outName == "" {
+		return fmt.Errorf("-std requires an output file via -o")
+	}
+	if *outName != "" && (*write || *quantiles != "") {
+		return fmt.Errorf("-w and -q cannot be combined with -o")
+	}
+
+	opts := libmbd.EnsembleOptions{Selection: *regex, Quantiles: []float64{},
+		Confidence: *confidence}
+	for _, s := range strings.Split(*quantiles, ",") {
+		if s = strings.TrimSpace(s); s == "" {
+			continue
+		}
+		q, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return fmt.Errorf("invalid quantile %s", s)
+		}
+		opts.Quantiles = append(opts.Quantiles, q)
+	}
+
+	var ens *libmbd.Ensemble
+	for _, n := range flags.Args() {
+		data, err := read(n)
+		if err != nil {
+			return err
+		}
+		if ens == nil {
+			// the time grid is determined by the first file
+			if resampleFlag > 0 {
+				if opts.Grid, opts.Method, err = resampleGrid(data); err != nil {
+					data.Close()
+					return err
+				}
+			}
+			if ens, err = libmbd.NewEnsemble(opts); err != nil {
+				data.Close()
+				return err
+			}
+		}
+		err = ens.Add(data)
+		data.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	if *outName != "" {
+		blocks, err := ens.DataBlocks(*withStd)
+		if err != nil {
+			return err
+		}
+		return writeAPI2(*outName, compressor, blocks, ens.TimeSpec(), *bufSize)
+	}
+
+	for _, name := range ens.DataNames() {
+		stats, err := ens.Stats(name)
+		if err != nil {
+			return err
+		}
+		if err := writeEnsembleStats(stats, *write); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeEnsembleStats writes the ensemble statistics of a dataset to stdout or
+// to a file named after the dataset. Each row holds the output time followed
+// by the statistics of each column.
+func writeEnsembleStats(stats *libmbd.EnsembleStats, toFile bool) error {
+	var output io.Writer = os.Stdout
+	if toFile {
+		file, err := os.Create(stats.Name + ensembleSuffix)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		output = file
+	} else {
+		fmt.Fprintf(output, "# dataset %s (%d files)\n", stats.Name, stats.N)
+	}
+
+	level := strconv.FormatFloat(100*stats.Confidence, 'g', -1, 64)
+	names := []string{"mean", "std", "min", "max"}
+	for _, q := range stats.Quantiles {
+		names = append(names, "q"+strconv.FormatFloat(q, 'g', -1, 64))
+	}
+	names = append(names, "ci"+level+"_low", "ci"+level+"_high")
+
+	header := []string{"time"}
+	numCols := len(stats.Mean)
+	for c := 0; c < numCols; c++ {
+		prefix := ""
+		if numCols > 1 || len(stats.Meta.Labels) != 0 || len(stats.Meta.Units) != 0 {
+			prefix = strings.Replace(columnHeader(stats.Meta, c), " ", "_", -1) + ":"
+		}
+		for _, n := range names {
+			header = append(header, prefix+n)
+		}
+	}
+	fmt.Fprintf(output, "# %s\n", strings.Join(header, " "))
+
+	std := stats.StdDev()
+	items := make([]string, 0, len(header))
+	for r, t := range stats.Times {
+		items = append(items[:0], fmt.Sprintf("%8.5e", t))
+		for c := 0; c < numCols; c++ {
+			values := []float64{stats.Mean[c][r], std[c][r], stats.Min[c][r],
+				stats.Max[c][r]}
+			for q := range stats.Quantiles {
+				values = append(values, stats.Quantile[q][c][r])
+			}
+			values = append(values, stats.CILow[c][r], stats.CIHigh[c][r])
+			for _, v := range values {
+				items = append(items, strconv.FormatFloat(v, 'g', -1, 64))
+			}
+		}
+		if _, err := fmt.Fprintln(output, strings.Join(items, " ")); err != nil {
+			return err
+		}
+	}
+	if !toFile {
+		fmt.Fprintln(output)
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/haskelladdict/mbdr/libmbd"
+	"github.com/haskelladdict/mbdr/parser"
+)
+
+// runFsck validates the structure of each provided binary mcell file against
+// its header and prints a per file report. An error is returned if at least
+// one file is corrupt.
+func runFsck(args []string) error {
+	flags := flag.NewFlagSet("fsck", flag.ExitOnError)
+	quiet := flags.Bool("q", false, "only report corrupt files")
+	flags.Usage = func() {
+		fmt.Println("usage: mbdr fsck [options] <binary mcell files>")
+		fmt.Println("\noptions:")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return fmt.Errorf("fsck requires at least one file")
+	}
+
+	var numCorrupt int
+	for _, filename := range flags.Args() {
+		errs := checkFile(filename)
+		if len(errs) == 0 {
+			if !*quiet {
+				fmt.Printf("%s: OK\n", filename)
+			}
+			continue
+		}
+
+		numCorrupt++
+		fmt.Printf("%s: CORRUPT (%d problem(s))\n", filename, len(errs))
+		for _, e := range errs {
+			fmt.Printf("    %s\n", e)
+		}
+	}
+
+	if numCorrupt != 0 {
+		return fmt.Errorf("%d of %d files are corrupt", numCorrupt, flags.NArg())
+	}
+	return nil
+}
+
+// checkFile parses the header of the named file and validates the file's
+// structure against it
+func checkFile(filename string) []error {
+	var data *libmbd.MCellData
+	var dataLen uint64
+	var err error
+	if filename == "-" {
+		data, dataLen, err = parser.InspectFrom(os.Stdin)
+	} else {
+		data, dataLen, err = parser.Inspect(filename)
+	}
+	if data == nil {
+		return []error{err}
+	}
+
+	var errs []error
+	if err != nil {
+		errs = append(errs, err)
+	}
+	return append(errs, data.Validate(dataLen)...)
+}
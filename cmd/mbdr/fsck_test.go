@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/haskelladdict/mbdr/libmbd"
+)
+
+// TestFsck checks the problems reported by fsck for intact and damaged files
+func TestFsck(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mbdr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	content := func(names ...string) []byte {
+		var blocks []libmbd.DataBlock
+		for _, n := range names {
+			blocks = append(blocks, libmbd.DataBlock{Name: n, Data: &libmbd.CountData{
+				Col: [][]float64{{1, 2, 3}}, DataTypes: []uint16{libmbd.IntType}}})
+		}
+		var buf bytes.Buffer
+		spec := libmbd.TimeSpec{OutputListType: libmbd.Step, StepSize: 1e-6}
+		if err := libmbd.WriteAPI2(&buf, blocks, spec, 2); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+	valid := content("a", "b")
+
+	tests := []struct {
+		name    string
+		content []byte
+		kinds   []error // kinds of the expected problems
+	}{
+		{"valid", valid, nil},
+		{"truncated", valid[:len(valid)-4], []error{libmbd.ErrTruncated}},
+		{"trailing", append(append([]byte(nil), valid...), 0, 0), []error{libmbd.ErrCorrupt}},
+		{"duplicate names", content("a", "a"), []error{libmbd.ErrCorrupt}},
+		{"empty", nil, []error{libmbd.ErrEmpty}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filename := filepath.Join(dir, tt.name+".bin")
+			if err := ioutil.WriteFile(filename, tt.content, 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			errs := checkFile(filename)
+			if len(errs) != len(tt.kinds) {
+				t.Fatalf("got problems %v, want %d", errs, len(tt.kinds))
+			}
+			for i, err := range errs {
+				if !errors.Is(err, tt.kinds[i]) {
+					t.Errorf("got problem %v, want %v", err, tt.kinds[i])
+				}
+			}
+			if err := runFsck([]string{"-q", filename}); (err != nil) != (len(errs) != 0) {
+				t.Errorf("got fsck result %v for %d problems", err, len(errs))
+			}
+		})
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/haskelladdict/mbdr/parser"
+)
+
+// runIndex creates header index sidecar files for all binary mcell files
+// within the provided directories (or for the provided files)
+func runIndex(args []string) error {
+	flags := flag.NewFlagSet("index", flag.ExitOnError)
+	pattern := flags.String("p", "*.bin*", "pattern of data files within directories")
+	force := flags.Bool("f", false, "rebuild sidecars which are up to date")
+	quiet := flags.Bool("q", false, "only report failures")
+	flags.Usage = func() {
+		fmt.Println("usage: mbdr index [options] <directories or files>")
+		fmt.Printf("\nWrites the header of each file to <file>%s for fast metadata "+
+			"access.\n", parser.IndexSuffix)
+		fmt.Println("\noptions:")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return fmt.Errorf("index requires at least one directory or file")
+	}
+
+	fileNames, err := indexFiles(flags.Args(), *pattern)
+	if err != nil {
+		return err
+	}
+
+	var numFailed, numSkipped int
+	for _, n := range fileNames {
+		if !*force && parser.IndexCurrent(n) {
+			numSkipped++
+			continue
+		}
+		if err := parser.WriteIndex(n); err != nil {
+			fmt.Println(err)
+			numFailed++
+			continue
+		}
+		if !*quiet {
+			fmt.Printf("%s: indexed\n", n)
+		}
+	}
+	if !*quiet {
+		fmt.Printf("indexed %d of %d files (%d up to date)\n",
+			len(fileNames)-numFailed-numSkipped, len(fileNames), numSkipped)
+	}
+	if numFailed != 0 {
+		return fmt.Errorf("%d of %d files could not be indexed", numFailed, len(fileNames))
+	}
+	return nil
+}
+
+// indexFiles expands the provided directories into the list of contained
+// files matching pattern (excluding sidecars). Files are used as is.
+func indexFiles(paths []string, pattern string) ([]string, error) {
+	var fileNames []string
+	for _, p := range paths {
+		info, err := os.Stat(p)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			fileNames = append(fileNames, p)
+			continue
+		}
+
+		matches, err := filepath.Glob(filepath.Join(p, pattern))
+		if err != nil {
+			return nil, err
+		}
+		sort.Strings(matches)
+		for _, m := range matches {
+			if strings.HasSuffix(m, parser.IndexSuffix) ||
+				strings.HasSuffix(m, parser.MetaSuffix) {
+				continue
+			}
+			if info, err := os.Stat(m); err == nil && info.Mode().IsRegular() {
+				fileNames = append(fileNames, m)
+			}
+		}
+	}
+	return fileNames, nil
+}
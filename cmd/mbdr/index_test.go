@@ -0,0 +1,40 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/haskelladdict/mbdr/parser"
+)
+
+// TestIndexFiles checks that directories are expanded into the contained data
+// files without index and metadata sidecars
+func TestIndexFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mbdr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, n := range []string{"b.bin", "a.bin", "a.bin" + parser.IndexSuffix,
+		"a.bin" + parser.MetaSuffix, "b.bin" + parser.MetaSuffix} {
+		if err := ioutil.WriteFile(filepath.Join(dir, n), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Mkdir(filepath.Join(dir, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := indexFiles([]string{dir}, "*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{filepath.Join(dir, "a.bin"), filepath.Join(dir, "b.bin")}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
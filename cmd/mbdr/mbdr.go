@@ -1,10 +1,16 @@
 package main
 
 import (
+	"encoding/csv"
 	"flag"
 	"fmt"
 	"log"
+	"math"
 	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
 
 	"github.com/haskelladdict/mbdr/libmbd"
 	"github.com/haskelladdict/mbdr/parser"
@@ -21,26 +27,80 @@ var (
 	addTimesFlag  bool
 	writeFileFlag bool
 	extractFlag   bool
+	lazyFlag      bool
 	extractID     uint64
 	extractString string
 	extractRegex  string
+	fromFlag      float64
+	toFlag        float64
+	everyFlag     uint64
+	metaFile      string
+	csvFlag       bool
+	resampleFlag  float64
+	methodFlag    string
+	exprFlag      string
 )
 
+// command describes an mbdr subcommand
+type command struct {
+	run  func(args []string) error
+	info string
+}
+
+// list of available subcommands
+var commands = map[string]command{
+	"convert":  {runConvert, "convert a file to MCELL_BINARY_API_2"},
+	"diff":     {runDiff, "compare the datasets of two files numerically"},
+	"ensemble": {runEnsemble, "compute statistics of datasets across files (e.g. seeds)"},
+	"fsck":     {runFsck, "check the structure of files against their header"},
+	"index":    {runIndex, "write header index sidecars for fast metadata access"},
+	"merge":    {runMerge, "join checkpoint segments into a single file"},
+}
+
 func init() {
-	flag.BoolVar(&infoFlag, "i", false, "show general info")
+	flag.BoolVar(&infoFlag, "i", false, "show general info (lists supported formats "+
+		"if no file is given)")
 	flag.BoolVar(&listFlag, "l", false, "list available data blocks")
 	flag.BoolVar(&extractFlag, "e", false, "extract dataset")
 	flag.BoolVar(&addTimesFlag, "t", false, "add output times column")
 	flag.BoolVar(&writeFileFlag, "w", false, "write output to file")
+	flag.BoolVar(&lazyFlag, "L", false, "only decode the requested dataset(s) to keep "+
+		"memory use small\n\t(requires uncompressed or bzip2 compressed files)")
 	flag.Uint64Var(&extractID, "I", 0, "id of dataset to extract")
 	flag.StringVar(&extractString, "N", "", "name of dataset to extract")
 	flag.StringVar(&extractRegex, "R", "", "regular expression of dataset(s) to extract")
+	flag.StringVar(&exprFlag, "x", "", "extract dataset derived via expression, e.g. "+
+		"'bound = bound_A + bound_B'\n\t(see libmbd.Expr for the syntax)")
+	flag.Float64Var(&fromFlag, "from", 0, "only extract rows with output times >= from")
+	flag.Float64Var(&toFlag, "to", math.Inf(1), "only extract rows with output times <= to")
+	flag.Uint64Var(&everyFlag, "every", 1, "only extract every n-th row")
+	flag.BoolVar(&csvFlag, "csv", false, "extract dataset(s) as a single CSV table with "+
+		"one column per data column")
+	flag.StringVar(&metaFile, "m", "", "metadata sidecar or MDL file providing column "+
+		"labels\n\t("+metaAuto+" uses the sidecar of each data file if present)")
+	flag.Float64Var(&resampleFlag, "resample", 0, "resample dataset(s) onto a time grid "+
+		"with the given spacing")
+	flag.StringVar(&methodFlag, "method", "hold", "resampling method (hold, linear, "+
+		"average)")
 }
 
 // main function entry point
 func main() {
+	if len(os.Args) > 1 {
+		if cmd, ok := commands[os.Args[1]]; ok {
+			if err := cmd.run(os.Args[2:]); err != nil {
+				log.Fatal(err)
+			}
+			return
+		}
+	}
+
 	flag.Parse()
 	if len(flag.Args()) == 0 {
+		if infoFlag {
+			showFormats()
+			return
+		}
 		usage()
 		return
 	}
@@ -49,15 +109,15 @@ func main() {
 		var data *libmbd.MCellData
 		var err error
 		if infoFlag || listFlag {
-			if data, err = parser.ReadHeader(filename); err != nil {
+			if data, err = readHeader(filename); err != nil {
 				log.Fatal(err)
 			}
-		} else if extractFlag {
-			if data, err = parser.Read(filename); err != nil {
+		} else if extractFlag || exprFlag != "" {
+			if data, err = read(filename); err != nil {
 				log.Fatal(err)
 			}
 		} else {
-			fmt.Println("\nError: Please specify at least one of -i, -l, or -e!")
+			fmt.Println("\nError: Please specify at least one of -i, -l, -e, or -x!")
 			usage()
 			return
 		}
@@ -69,19 +129,102 @@ func main() {
 		case listFlag:
 			showAvailableData(data)
 
-		case extractFlag:
+		case extractFlag || exprFlag != "":
 			if err := extractData(data); err != nil {
 				log.Fatal(err)
 			}
 		}
+		data.Close()
 	}
 }
 
 // usage prints a brief usage information to stdout
 func usage() {
 	fmt.Println("usage: mbdr [options] <binary mcell filename>")
+	fmt.Println("       mbdr <command> [options] <arguments>")
+	fmt.Println("\nUse - as filename to read from stdin.")
 	fmt.Println("\noptions:")
 	flag.PrintDefaults()
+
+	var names []string
+	for n := range commands {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	fmt.Println("\ncommands:")
+	for _, n := range names {
+		fmt.Printf("  %-10s %s\n", n, commands[n].info)
+	}
+}
+
+// readHeader parses the header of the named binary mcell file. A filename of
+// "-" reads the data from stdin.
+func readHeader(filename string) (*libmbd.MCellData, error) {
+	var data *libmbd.MCellData
+	var err error
+	if filename == "-" {
+		data, err = parser.ReadHeaderFrom(os.Stdin)
+	} else {
+		data, err = parser.ReadHeader(filename)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := applyMeta(data); err != nil {
+		data.Close()
+		return nil, err
+	}
+	return data, nil
+}
+
+// read parses header and data of the named binary mcell file. A filename of
+// "-" reads the data from stdin.
+func read(filename string) (*libmbd.MCellData, error) {
+	var data *libmbd.MCellData
+	var err error
+	switch {
+	case filename == "-":
+		data, err = parser.ReadFrom(os.Stdin)
+	case lazyFlag:
+		data, err = parser.ReadLazy(filename)
+	default:
+		data, err = parser.Read(filename)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := applyMeta(data); err != nil {
+		data.Close()
+		return nil, err
+	}
+	return data, nil
+}
+
+// metaAuto is the value of the -m flag requesting the metadata sidecar of
+// each data file
+const metaAuto = "auto"
+
+// applyMeta attaches the metadata provided via the -m flag to the data blocks.
+// With -m auto the metadata sidecar of the data file is used if present. Since
+// the data remain valid without their metadata, problems with the sidecar are
+// only reported as warning in this case.
+func applyMeta(data *libmbd.MCellData) error {
+	switch metaFile {
+	case "":
+		return nil
+	case metaAuto:
+		if data.FileName() != "" {
+			if err := parser.LoadMeta(data, data.FileName()); err != nil {
+				log.Printf("warning: ignoring metadata: %s", err)
+			}
+		}
+		return nil
+	}
+	meta, err := parser.ReadMeta(metaFile)
+	if err != nil {
+		return err
+	}
+	return data.SetMeta(meta)
 }
 
 // showInfo provides general info regarding the nature and amount of data
@@ -90,7 +233,7 @@ func showInfo(d *libmbd.MCellData) {
 	fmt.Printf("This is mbdr version %s        (C) %s M. Dittrich\n", version.Tag,
 		version.Year)
 	fmt.Println("------------------------------------------------------------------")
-	fmt.Printf("mbdr> output was generated using %s\n", d.API)
+	fmt.Printf("mbdr> output was generated using %s\n", d.API())
 	fmt.Printf("mbdr> found %d output data blocks with %d output iterations each\n",
 		d.NumDataBlocks(), d.BlockLen())
 	switch d.OutputType() {
@@ -108,49 +251,143 @@ func showInfo(d *libmbd.MCellData) {
 	}
 }
 
+// showFormats lists the supported data and compression formats
+func showFormats() {
+	fmt.Printf("This is mbdr version %s        (C) %s M. Dittrich\n", version.Tag,
+		version.Year)
+	fmt.Println("------------------------------------------------------------------")
+	fmt.Println("mbdr> supported data formats:")
+	for _, f := range parser.Formats() {
+		fmt.Printf("mbdr>     %s\n", f)
+	}
+	fmt.Printf("mbdr>     %s (directories and *.dat files)\n", libmbd.ASCII)
+
+	var names []string
+	compressions := parser.Compressions()
+	for n := range compressions {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	fmt.Println("mbdr> supported compression formats:")
+	for _, n := range names {
+		if compressions[n] {
+			fmt.Printf("mbdr>     %s\n", n)
+		} else {
+			fmt.Printf("mbdr>     %s (no decompressor registered)\n", n)
+		}
+	}
+}
+
 // showAvailableData shows the available data sets contained in the
-// binary output file
+// binary output file together with their column labels and units (if known)
 func showAvailableData(d *libmbd.MCellData) {
 	for i, n := range d.DataNames() {
-		fmt.Printf("[%d] %s\n", i, n)
+		meta := d.BlockMetaByID(uint64(i))
+		if len(meta.Labels) == 0 && len(meta.Units) == 0 {
+			fmt.Printf("[%d] %s\n", i, n)
+			continue
+		}
+
+		var cols []string
+		for c := 0; c < len(meta.Labels) || c < len(meta.Units); c++ {
+			cols = append(cols, columnHeader(meta, c))
+		}
+		fmt.Printf("[%d] %s: %s\n", i, n, strings.Join(cols, ", "))
 	}
 }
 
+// columnHeader returns the label of column c including its unit (if any).
+// Unlabeled columns are named after their index.
+func columnHeader(meta libmbd.BlockMeta, c int) string {
+	label := meta.Label(c)
+	if label == "" {
+		label = fmt.Sprintf("col%d", c)
+	}
+	if unit := meta.Unit(c); unit != "" {
+		label = fmt.Sprintf("%s [%s]", label, unit)
+	}
+	return label
+}
+
 // extractData extracts the content of a data set or data sets either at the
-// requested ID, the provided name, or the regular expression and writes it to
-// stdout or files if requested.
-// NOTE: This routine doesn't bother with converting to integer column data
-// (as determined by DataTypes) and simply prints everything as double
+// requested ID, the provided name, the regular expression, or derived via the
+// provided expression and writes it to stdout or files if requested. Only the
+// rows within the requested time window and stride are extracted.
 func extractData(data *libmbd.MCellData) error {
 
-	outputData := make(map[string]*libmbd.CountData)
-	var countData *libmbd.CountData
-	var err error
-
-	if extractString != "" {
+	var ids []uint64
+	if exprFlag != "" {
+		derived, err := evalExpr(data)
+		if err != nil {
+			return err
+		}
+		data = derived
+		ids = append(ids, 0)
+	} else if extractString != "" {
 		// if match string was supplied we'll use it
-		if countData, err = data.BlockDataByName(extractString); err != nil {
+		id, err := data.BlockNameToID(extractString)
+		if err != nil {
 			return err
 		}
-		outputData[extractString] = countData
+		ids = append(ids, id)
 	} else if extractRegex != "" {
-		if outputData, err = data.BlockDataByRegex(extractRegex); err != nil {
+		regex, err := regexp.Compile(extractRegex)
+		if err != nil {
 			return err
 		}
+		for id, n := range data.DataNames() {
+			if regex.MatchString(n) {
+				ids = append(ids, uint64(id))
+			}
+		}
 	} else {
 		// otherwise we pick the supplied data set ID to extract (0 by default)
-		if countData, err = data.BlockDataByID(extractID); err != nil {
+		ids = append(ids, extractID)
+	}
+
+	if everyFlag == 0 {
+		return fmt.Errorf("-every requires a positive number of rows")
+	}
+
+	if resampleFlag > 0 {
+		grid, method, err := resampleGrid(data)
+		if err != nil {
 			return err
 		}
-		var name string
-		if name, err = data.IDtoBlockName(extractID); err != nil {
+		if data, err = data.Resample(grid, method, ids...); err != nil {
 			return err
 		}
-		outputData[name] = countData
+		for i := range ids {
+			ids[i] = uint64(i)
+		}
+	}
+	from, to := uint64(0), data.BlockLen()
+	if fromFlag > 0 || !math.IsInf(toFlag, 1) {
+		from, to = data.RowsInTimeRange(fromFlag, toFlag)
+	}
+
+	if csvFlag {
+		return writeCSV(data, ids, from, to)
+	}
+
+	var outputTimes []float64
+	if addTimesFlag {
+		times := data.OutputTimes()
+		for r := from; r < to; r += everyFlag {
+			outputTimes = append(outputTimes, times[r])
+		}
 	}
 
-	for name, col := range outputData {
-		if err = writeData(data, name, col); err != nil {
+	for _, id := range ids {
+		name, err := data.IDtoBlockName(id)
+		if err != nil {
+			return err
+		}
+		countData, err := data.BlockDataByIDRows(id, from, to, everyFlag)
+		if err != nil {
+			return err
+		}
+		if err = writeData(name, countData, outputTimes); err != nil {
 			return err
 		}
 	}
@@ -158,14 +395,104 @@ func extractData(data *libmbd.MCellData) error {
 	return nil
 }
 
-// writeData writes the supplied count data corresponding to the named data set
-// to stdout or a file
-func writeData(d *libmbd.MCellData, name string, data *libmbd.CountData) error {
+// evalExpr evaluates the expression provided via -x and returns the result as
+// in-memory data with a single data block. The expression may be preceded by
+// "<name> =" to name the data block, otherwise it is named "derived".
+func evalExpr(data *libmbd.MCellData) (*libmbd.MCellData, error) {
+	name, expr := "derived", exprFlag
+	// a leading name= only names the result if name is a valid identifier,
+	// otherwise = is part of the expression (e.g. of a quoted name or regex)
+	if lhs, rhs, ok := strings.Cut(exprFlag, "="); ok {
+		switch lhs = strings.TrimSpace(lhs); {
+		case lhs == "":
+			return nil, fmt.Errorf("missing dataset name in expression %s", exprFlag)
+		case libmbd.IsIdentifier(lhs):
+			name, expr = lhs, rhs
+		}
+	}
 
-	var outputTimes []float64
-	if addTimesFlag {
-		outputTimes = d.OutputTimes()
+	countData, err := data.Eval(expr)
+	if err != nil {
+		return nil, err
+	}
+	derived, err := libmbd.NewRawData([]libmbd.DataBlock{{Name: name, Data: countData}},
+		data.TimeSpec())
+	if err != nil {
+		return nil, err
+	}
+	derived.FileName = data.FileName()
+	return libmbd.FromRaw(derived), nil
+}
+
+// resampleGrid returns the time grid with the spacing requested via -resample
+// covering the output times of data as well as the resampling method
+// requested via -method
+func resampleGrid(data *libmbd.MCellData) ([]float64, libmbd.ResampleMethod, error) {
+	method, err := libmbd.ParseResampleMethod(methodFlag)
+	if err != nil {
+		return nil, method, err
+	}
+	times := data.OutputTimes()
+	if len(times) == 0 {
+		return nil, method, fmt.Errorf("%s: can not resample data without output times",
+			data.FileName())
+	}
+	grid, err := libmbd.UniformGrid(times[0], times[len(times)-1], resampleFlag)
+	return grid, method, err
+}
+
+// writeCSV writes every everyFlag-th row within [from, to) of the data blocks
+// with the given IDs as a single CSV table with a leading time column to
+// stdout. Rows are streamed so only a single stream block of each data block
+// is held in memory at a time.
+func writeCSV(data *libmbd.MCellData, ids []uint64, from, to uint64) error {
+	it, err := data.RowsInRange(ids, from, to, everyFlag)
+	if err != nil {
+		return err
+	}
+
+	w := csv.NewWriter(os.Stdout)
+	cols := it.Columns()
+	record := make([]string, len(cols)+1)
+	numCols := make(map[string]int)
+	for _, c := range cols {
+		numCols[c.Block]++
+	}
+	record[0] = "time"
+	for i, c := range cols {
+		record[i+1] = c.Block
+		switch {
+		case c.Label != "":
+			record[i+1] += ":" + c.Label
+		case numCols[c.Block] > 1:
+			record[i+1] += fmt.Sprintf(":col%d", c.Col)
+		}
+	}
+	if err := w.Write(record); err != nil {
+		return err
+	}
+
+	for it.Next() {
+		record[0] = strconv.FormatFloat(it.Time(), 'g', -1, 64)
+		for i := range cols {
+			record[i+1] = it.FormatValue(i)
+		}
+		if err := w.Write(record); err != nil {
+			return err
+		}
+	}
+	if err := it.Err(); err != nil {
+		return err
 	}
+	w.Flush()
+	return w.Error()
+}
+
+// writeData writes the supplied count data corresponding to the named data set
+// to stdout or a file. If outputTimes is not nil, each row is preceded by its
+// output time. Integer columns are written as integers. If column labels are
+// known they are written as a leading comment line.
+func writeData(name string, data *libmbd.CountData, outputTimes []float64) error {
 
 	output := os.Stdout
 	var err error
@@ -173,19 +500,32 @@ func writeData(d *libmbd.MCellData, name string, data *libmbd.CountData) error {
 		if output, err = os.Create(name); err != nil {
 			return err
 		}
+		defer output.Close()
 	}
 
 	numCols := len(data.Col)
 	numRows := len(data.Col[0])
+	if len(data.Meta.Labels) != 0 || len(data.Meta.Units) != 0 {
+		var header []string
+		if outputTimes != nil {
+			header = append(header, "time")
+		}
+		for c := 0; c < numCols; c++ {
+			header = append(header, strings.Replace(columnHeader(data.Meta, c), " ", "_", -1))
+		}
+		fmt.Fprintf(output, "# %s\n", strings.Join(header, " "))
+	}
+
+	items := make([]string, 0, numCols+1)
 	for r := 0; r < numRows; r++ {
+		items = items[:0]
+		if outputTimes != nil {
+			items = append(items, fmt.Sprintf("%8.5e", outputTimes[r]))
+		}
 		for c := 0; c < numCols; c++ {
-			if addTimesFlag {
-				fmt.Fprintf(output, "%8.5e %g", outputTimes[r], data.Col[c][r])
-			} else {
-				fmt.Fprintf(output, "%g", data.Col[c][r])
-			}
+			items = append(items, data.FormatValue(c, r))
 		}
-		fmt.Fprintf(output, "\n")
+		fmt.Fprintln(output, strings.Join(items, " "))
 	}
 	return nil
 }
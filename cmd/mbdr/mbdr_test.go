@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/haskelladdict/mbdr/libmbd"
+	"github.com/haskelladdict/mbdr/parser"
+)
+
+// TestReadMeta checks that the metadata sidecar of a data file is only used
+// if requested via -m auto
+func TestReadMeta(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mbdr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var buf bytes.Buffer
+	blocks := []libmbd.DataBlock{{Name: "a", Data: &libmbd.CountData{
+		Col: [][]float64{{1, 2}}, DataTypes: []uint16{libmbd.IntType}}}}
+	spec := libmbd.TimeSpec{OutputListType: libmbd.Step, StepSize: 1e-6}
+	if err := libmbd.WriteAPI2(&buf, blocks, spec, 2); err != nil {
+		t.Fatal(err)
+	}
+	filename := filepath.Join(dir, "a.bin")
+	if err := ioutil.WriteFile(filename, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := parser.WriteMeta(filename+parser.MetaSuffix,
+		map[string]libmbd.BlockMeta{"a": {Labels: []string{"sidecar"}}}); err != nil {
+		t.Fatal(err)
+	}
+	mdl := filepath.Join(dir, "model.mdl")
+	if err := ioutil.WriteFile(mdl, []byte(`{COUNT[ca, WORLD] : "mdl"} => "a"`),
+		0644); err != nil {
+		t.Fatal(err)
+	}
+
+	defer func(m string) { metaFile = m }(metaFile)
+	tests := []struct {
+		meta  string
+		label string
+	}{
+		{"", ""},
+		{metaAuto, "sidecar"},
+		{mdl, "mdl"},
+	}
+	for _, tt := range tests {
+		metaFile = tt.meta
+		data, err := read(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if l := data.BlockMetaByID(0).Label(0); l != tt.label {
+			t.Errorf("-m %q: got label %q, want %q", tt.meta, l, tt.label)
+		}
+		data.Close()
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/haskelladdict/mbdr/libmbd"
+)
+
+// runMerge joins the checkpoint segments of a single simulation seed into one
+// continuous MCELL_BINARY_API_2 file
+func runMerge(args []string) error {
+	flags := flag.NewFlagSet("merge", flag.ExitOnError)
+	compression := flags.String("c", "gzip", "compression of output file (none, gzip)")
+	bufSize := flags.Uint64("b", libmbd.DefaultOutputBufSize, "number of rows per "+
+		"stream block")
+	starts := flags.String("s", "", "comma separated start times of the segments "+
+		"(STEP output only,\n\tdefaults to consecutive segments starting at 0)")
+	flags.Usage = func() {
+		fmt.Println("usage: mbdr merge [options] <segment files> <output file>")
+		fmt.Println("\nSegment files have to be given in chronological order.")
+		fmt.Println("\noptions:")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() < 2 {
+		flags.Usage()
+		return fmt.Errorf("merge requires at least one segment and an output file")
+	}
+	inNames, outName := flags.Args()[:flags.NArg()-1], flags.Arg(flags.NArg()-1)
+
+	compressor, ok := compressors[*compression]
+	if !ok {
+		return fmt.Errorf("compression %s is not supported for writing", *compression)
+	}
+	if *bufSize == 0 {
+		return fmt.Errorf("number of rows per stream block has to be positive")
+	}
+
+	var opts libmbd.MergeOptions
+	if *starts != "" {
+		for _, s := range strings.Split(*starts, ",") {
+			t, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+			if err != nil {
+				return fmt.Errorf("invalid segment start time %s", s)
+			}
+			opts.Starts = append(opts.Starts, t)
+		}
+	}
+
+	var segments []*libmbd.MCellData
+	defer func() {
+		for _, s := range segments {
+			s.Close()
+		}
+	}()
+	for _, n := range inNames {
+		data, err := read(n)
+		if err != nil {
+			return err
+		}
+		segments = append(segments, data)
+	}
+
+	merged, err := libmbd.Merge(segments, opts)
+	if err != nil {
+		return err
+	}
+	blocks, err := merged.DataBlocks()
+	if err != nil {
+		return err
+	}
+	return writeAPI2(outName, compressor, blocks, merged.TimeSpec(), *bufSize)
+}
@@ -0,0 +1,74 @@
+package libmbd
+
+import (
+	"strconv"
+)
+
+// Number is the set of element types data columns can be retrieved as via
+// Column
+type Number interface {
+	int | int32 | int64 | uint32 | uint64 | float32 | float64
+}
+
+// NumCols returns the number of data columns
+func (c *CountData) NumCols() int {
+	return len(c.Col)
+}
+
+// IsInt tests if column i holds integer data
+func (c *CountData) IsInt(i int) bool {
+	return i >= 0 && i < len(c.DataTypes) && c.DataTypes[i] == IntType
+}
+
+// FloatCol returns column i as float64 values. This works for both integer
+// and double columns and returns the underlying storage without copying.
+func (c *CountData) FloatCol(i int) ([]float64, error) {
+	if i < 0 || i >= len(c.Col) {
+		return nil, NewError(ErrOutOfRange, "column %d is out of range", i)
+	}
+	return c.Col[i], nil
+}
+
+// IntCol returns the integer column i as int64 values. Requesting a double
+// column results in an ErrDataTypeMismatch error.
+func (c *CountData) IntCol(i int) ([]int64, error) {
+	return Column[int64](c, i)
+}
+
+// Column returns column i of the count data converted to T. Integer data are
+// stored as float64 which represents all counts exactly so the conversion is
+// lossless. Converting a double column to an integer type results in an
+// ErrDataTypeMismatch error.
+// NOTE: Each call creates a new slice. Use FloatCol to access the column
+// data without copying.
+func Column[T Number](c *CountData, i int) ([]T, error) {
+	col, err := c.FloatCol(i)
+	if err != nil {
+		return nil, err
+	}
+
+	var zero T
+	switch any(zero).(type) {
+	case float32, float64:
+	default:
+		if !c.IsInt(i) {
+			return nil, NewError(ErrDataTypeMismatch, "column %d does not hold integer data", i)
+		}
+	}
+
+	out := make([]T, len(col))
+	for r, v := range col {
+		out[r] = T(v)
+	}
+	return out, nil
+}
+
+// FormatValue returns the string representation of the value in row r of
+// column i according to the column's data type, i.e. integer data are
+// formatted as integers and doubles in %g format.
+func (c *CountData) FormatValue(i, r int) string {
+	if c.IsInt(i) {
+		return strconv.FormatInt(int64(c.Col[i][r]), 10)
+	}
+	return strconv.FormatFloat(c.Col[i][r], 'g', -1, 64)
+}
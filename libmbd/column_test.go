@@ -0,0 +1,66 @@
+package libmbd
+
+import (
+	"errors"
+	"testing"
+)
+
+// TestColumnAccessors checks the type and range checks of the column
+// accessors
+func TestColumnAccessors(t *testing.T) {
+	c := &CountData{Col: [][]float64{{1, 2}, {0.5, 1.5}},
+		DataTypes: []uint16{IntType, DoubleType}}
+
+	tests := []struct {
+		name string
+		get  func() error
+		kind error
+	}{
+		{"IntCol int", func() error { _, err := c.IntCol(0); return err }, nil},
+		{"IntCol double", func() error { _, err := c.IntCol(1); return err },
+			ErrDataTypeMismatch},
+		{"IntCol out of range", func() error { _, err := c.IntCol(2); return err },
+			ErrOutOfRange},
+		{"IntCol negative", func() error { _, err := c.IntCol(-1); return err },
+			ErrOutOfRange},
+		{"FloatCol double", func() error { _, err := c.FloatCol(1); return err }, nil},
+		{"FloatCol out of range", func() error { _, err := c.FloatCol(2); return err },
+			ErrOutOfRange},
+		{"Column uint32 double", func() error { _, err := Column[uint32](c, 1); return err },
+			ErrDataTypeMismatch},
+		{"Column float32 double", func() error { _, err := Column[float32](c, 1); return err },
+			nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.get()
+			if tt.kind == nil && err != nil {
+				t.Errorf("unexpected error %s", err)
+			} else if tt.kind != nil && !errors.Is(err, tt.kind) {
+				t.Errorf("got error %v, want %v", err, tt.kind)
+			}
+		})
+	}
+}
+
+// TestIntColExact checks that IntCol returns integer counts exactly up to
+// 2^53, the largest range of integers float64 represents without gaps
+func TestIntColExact(t *testing.T) {
+	const max = int64(1) << 53
+	want := []int64{0, 1, -1, max - 1, max, -max + 1, -max}
+	col := make([]float64, len(want))
+	for i, v := range want {
+		col[i] = float64(v)
+	}
+	c := &CountData{Col: [][]float64{col}, DataTypes: []uint16{IntType}}
+
+	got, err := c.IntCol(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("row %d: got %d, want %d", i, got[i], want[i])
+		}
+	}
+}
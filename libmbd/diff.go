@@ -0,0 +1,240 @@
+package libmbd
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+)
+
+// DiffOptions controls how Diff compares two data sets
+type DiffOptions struct {
+	// Selection is a regular expression selecting the data blocks to compare.
+	// All data blocks are compared if it is empty.
+	Selection string
+	// Two values a and b are considered equal if
+	// |a - b| <= AbsTol + RelTol * max(|a|, |b|)
+	AbsTol float64
+	RelTol float64
+	// Grid is a time grid both data sets are resampled onto via Method
+	// before comparing them (see Resample). If nil, the values are compared
+	// row by row for all rows with matching output times.
+	Grid   []float64
+	Method ResampleMethod
+}
+
+// DiffReport describes the differences between two data sets a and b. The
+// output times are only compared if the data are not resampled onto a grid.
+type DiffReport struct {
+	FileA    string      `json:"file_a"`
+	FileB    string      `json:"file_b"`
+	Missing  []string    `json:"missing,omitempty"`   // data blocks of a missing in b
+	Extra    []string    `json:"extra,omitempty"`     // data blocks of b missing in a
+	TimeSpec []string    `json:"time_spec,omitempty"` // differences of the output times
+	Blocks   []BlockDiff `json:"blocks"`              // data blocks present in both
+}
+
+// BlockDiff describes the differences of a data block present in both data
+// sets. The values are only compared if the number of columns agrees and
+// the data sets share output times (or are resampled onto a common grid).
+// NaN values are equal to each other and, like infinite values, diverge from
+// all other values but are not accounted for in the maximum errors.
+type BlockDiff struct {
+	Name           string  `json:"name"`
+	NumColsA       int     `json:"num_cols_a"`
+	NumColsB       int     `json:"num_cols_b"`
+	TypeMismatches []int   `json:"type_mismatches,omitempty"` // columns with differing data types
+	Compared       bool    `json:"compared"`                  // whether values were compared
+	NumRows        int     `json:"num_rows"`                  // number of compared rows
+	MaxAbsErr      float64 `json:"max_abs_err"`
+	MaxRelErr      float64 `json:"max_rel_err"`
+	Diverged       bool    `json:"diverged"` // whether any value differs beyond tolerance
+	FirstRow       int     `json:"first_row"`
+	FirstTime      float64 `json:"first_time"` // output time of the first diverging row
+}
+
+// Equal tests if the data block is identical within tolerance in both data
+// sets
+func (b *BlockDiff) Equal() bool {
+	return b.NumColsA == b.NumColsB && len(b.TypeMismatches) == 0 && b.Compared &&
+		!b.Diverged
+}
+
+// Equal tests if both data sets contain the same data blocks with identical
+// output times and values (within tolerance)
+func (r *DiffReport) Equal() bool {
+	if len(r.Missing) != 0 || len(r.Extra) != 0 || len(r.TimeSpec) != 0 {
+		return false
+	}
+	for i := range r.Blocks {
+		if !r.Blocks[i].Equal() {
+			return false
+		}
+	}
+	return true
+}
+
+// Diff compares the data blocks of a and b by name. It reports data blocks
+// present in only one of them, differences of the output times, and for each
+// common data block mismatches of the column layout as well as the maximum
+// absolute and relative errors and the first diverging output time. If the
+// data are resampled onto opts.Grid, differing output times are expected and
+// not reported.
+func Diff(a, b *MCellData, opts DiffOptions) (*DiffReport, error) {
+	var regex *regexp.Regexp
+	if opts.Selection != "" {
+		var err error
+		if regex, err = regexp.Compile(opts.Selection); err != nil {
+			return nil, err
+		}
+	}
+	selected := func(name string) bool {
+		return regex == nil || regex.MatchString(name)
+	}
+
+	report := &DiffReport{FileA: a.fileName, FileB: b.fileName}
+	if opts.Grid == nil {
+		report.TimeSpec = diffTimes(a, b)
+	}
+	for _, name := range b.blockNames {
+		if _, ok := a.blockNameMap[name]; !ok && selected(name) {
+			report.Extra = append(report.Extra, name)
+		}
+	}
+
+	// without resampling only the rows with matching output times are compared
+	timesA, timesB := a.outputTimes(), b.outputTimes()
+	numRows := len(timesA)
+	if len(timesB) < numRows {
+		numRows = len(timesB)
+	}
+	for r := 0; r < numRows; r++ {
+		if math.Abs(timesA[r]-timesB[r]) > roundOff(math.Max(math.Abs(timesA[r]),
+			math.Abs(timesB[r]))) {
+			numRows = r
+			break
+		}
+	}
+	times := timesA[:numRows]
+	compare := numRows > 0 || (len(timesA) == 0 && len(timesB) == 0)
+	if opts.Grid != nil {
+		times, compare = opts.Grid, true
+	}
+
+	for _, name := range a.blockNames {
+		if !selected(name) {
+			continue
+		}
+		if _, ok := b.blockNameMap[name]; !ok {
+			report.Missing = append(report.Missing, name)
+			continue
+		}
+
+		ca, err := a.BlockDataByName(name)
+		if err != nil {
+			return nil, err
+		}
+		cb, err := b.BlockDataByName(name)
+		if err != nil {
+			return nil, err
+		}
+		if opts.Grid != nil {
+			if ca, err = Resample(ca, timesA, opts.Grid, opts.Method); err != nil {
+				return nil, WithFile(err, a.fileName)
+			}
+			if cb, err = Resample(cb, timesB, opts.Grid, opts.Method); err != nil {
+				return nil, WithFile(err, b.fileName)
+			}
+		}
+		report.Blocks = append(report.Blocks, diffBlock(name, ca, cb, times, compare,
+			opts))
+	}
+	return report, nil
+}
+
+// diffTimes describes the differences between the output times of a and b
+func diffTimes(a, b *MCellData) []string {
+	var diffs []string
+	if a.OutputType() != b.OutputType() {
+		diffs = append(diffs, fmt.Sprintf("output type %s vs %s",
+			outputTypeName(a.OutputType()), outputTypeName(b.OutputType())))
+	} else if a.OutputType() == Step && a.OutputStepLen() != b.OutputStepLen() {
+		diffs = append(diffs, fmt.Sprintf("step size %g vs %g", a.OutputStepLen(),
+			b.OutputStepLen()))
+	}
+
+	timesA, timesB := a.outputTimes(), b.outputTimes()
+	if len(timesA) != len(timesB) {
+		diffs = append(diffs, fmt.Sprintf("%d vs %d output times", len(timesA),
+			len(timesB)))
+	}
+	for r := 0; r < len(timesA) && r < len(timesB); r++ {
+		if math.Abs(timesA[r]-timesB[r]) > roundOff(math.Max(math.Abs(timesA[r]),
+			math.Abs(timesB[r]))) {
+			diffs = append(diffs, fmt.Sprintf("output time of row %d is %g vs %g", r,
+				timesA[r], timesB[r]))
+			break
+		}
+	}
+	return diffs
+}
+
+// diffBlock compares the column layout and, if requested, the first
+// len(times) rows of the count data of data block name
+func diffBlock(name string, a, b *CountData, times []float64, compare bool,
+	opts DiffOptions) BlockDiff {
+
+	diff := BlockDiff{Name: name, NumColsA: len(a.Col), NumColsB: len(b.Col),
+		FirstRow: -1}
+	for c := 0; c < len(a.DataTypes) && c < len(b.DataTypes); c++ {
+		if a.DataTypes[c] != b.DataTypes[c] {
+			diff.TypeMismatches = append(diff.TypeMismatches, c)
+		}
+	}
+	if len(a.Col) != len(b.Col) || !compare {
+		return diff
+	}
+
+	diff.Compared = true
+	diff.NumRows = len(times)
+	for c := range a.Col {
+		for r := 0; r < len(times) && r < len(a.Col[c]) && r < len(b.Col[c]); r++ {
+			x, y := a.Col[c][r], b.Col[c][r]
+			var diverged bool
+			switch {
+			case x == y:
+
+			case math.IsNaN(x) || math.IsNaN(y) || math.IsInf(x, 0) || math.IsInf(y, 0):
+				diverged = !(math.IsNaN(x) && math.IsNaN(y))
+
+			default:
+				absErr := math.Abs(x - y)
+				scale := math.Max(math.Abs(x), math.Abs(y))
+				diff.MaxAbsErr = math.Max(diff.MaxAbsErr, absErr)
+				if scale > 0 {
+					diff.MaxRelErr = math.Max(diff.MaxRelErr, absErr/scale)
+				}
+				diverged = absErr > opts.AbsTol+opts.RelTol*scale
+			}
+
+			if diverged && (!diff.Diverged || r < diff.FirstRow) {
+				diff.Diverged = true
+				diff.FirstRow = r
+				diff.FirstTime = times[r]
+			}
+		}
+	}
+	return diff
+}
+
+// outputTypeName returns the name of the provided output type
+func outputTypeName(t uint16) string {
+	switch t {
+	case Step:
+		return "STEP"
+	case TimeListType:
+		return "TIME_LIST"
+	case IterationListType:
+		return "ITERATION_LIST"
+	}
+	return "UNKNOWN"
+}
@@ -0,0 +1,34 @@
+package libmbd
+
+import "testing"
+
+// TestDiffGrid checks that data with differing output times are equal once
+// they are resampled onto a common grid
+func TestDiffGrid(t *testing.T) {
+	newData := func(step float64, col []float64) *MCellData {
+		d, err := NewMCellData([]DataBlock{{"a", &CountData{Col: [][]float64{col},
+			DataTypes: []uint16{IntType}}}}, TimeSpec{OutputListType: Step,
+			StepSize: step})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return d
+	}
+	a, b := newData(1, []float64{1, 1}), newData(0.5, []float64{1, 1, 1})
+
+	report, err := Diff(a, b, DiffOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Equal() {
+		t.Errorf("data with differing output times are equal")
+	}
+
+	report, err = Diff(a, b, DiffOptions{Grid: []float64{0, 0.5, 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.Equal() {
+		t.Errorf("resampled data differ: %+v", report)
+	}
+}
@@ -0,0 +1,262 @@
+package libmbd
+
+import (
+	"regexp"
+	"sort"
+	"strconv"
+)
+
+// NamePattern parses structured data block names into named dimensions via a
+// regular expression with named capture groups, e.g.
+//
+//	bound_vesicle_(?P<ves>\d+)_(?P<sensor>sensor(?:_Y)?)_(?P<site>\d+)\.(?P<seed>\d+)\.dat
+//
+// parses bound_vesicle_03_sensor_02.0001.dat into ves=03, sensor=sensor,
+// site=02, and seed=0001.
+type NamePattern struct {
+	regex *regexp.Regexp
+	dims  []string
+}
+
+// CompileNamePattern compiles the regular expression describing the structure
+// of data block names. The expression has to contain at least one named
+// capture group and is matched against complete block names.
+func CompileNamePattern(expr string) (*NamePattern, error) {
+	regex, err := regexp.Compile("^(?:" + expr + ")$")
+	if err != nil {
+		return nil, err
+	}
+
+	p := &NamePattern{regex: regex}
+	for _, n := range regex.SubexpNames() {
+		if n != "" {
+			p.dims = append(p.dims, n)
+		}
+	}
+	if len(p.dims) == 0 {
+		return nil, NewError(ErrInvalidArgument, "name pattern %s has no named capture "+
+			"groups", expr)
+	}
+	return p, nil
+}
+
+// MustCompileNamePattern is like CompileNamePattern but panics if the
+// expression is invalid
+func MustCompileNamePattern(expr string) *NamePattern {
+	p, err := CompileNamePattern(expr)
+	if err != nil {
+		panic(err)
+	}
+	return p
+}
+
+// Dims returns the names of the dimensions in the order of their capture
+// groups
+func (p *NamePattern) Dims() []string {
+	return p.dims
+}
+
+// Parse returns the key/value map of dimensions encoded in the provided block
+// name. If the name doesn't match the pattern ok is false.
+func (p *NamePattern) Parse(name string) (keys map[string]string, ok bool) {
+	m := p.regex.FindStringSubmatch(name)
+	if m == nil {
+		return nil, false
+	}
+
+	keys = make(map[string]string)
+	for i, n := range p.regex.SubexpNames() {
+		if n != "" {
+			keys[n] = m[i]
+		}
+	}
+	return keys, true
+}
+
+// ParseNames parses the names of all data blocks matching the pattern. The
+// map keys are the block names.
+func (d *MCellData) ParseNames(p *NamePattern) map[string]map[string]string {
+	parsed := make(map[string]map[string]string)
+	for _, n := range d.blockNames {
+		if keys, ok := p.Parse(n); ok {
+			parsed[n] = keys
+		}
+	}
+	return parsed
+}
+
+// SelectByKeys returns the names of all data blocks (in order of their IDs)
+// which match the pattern and whose dimensions have the values requested in
+// sel, e.g. map[string]string{"ves": "03", "pulse": "2"}
+func (d *MCellData) SelectByKeys(p *NamePattern, sel map[string]string) []string {
+	var names []string
+	for _, n := range d.blockNames {
+		if keys, ok := p.Parse(n); ok && matchKeys(keys, sel) {
+			names = append(names, n)
+		}
+	}
+	return names
+}
+
+// matchKeys tests if keys contains all key/value pairs of sel
+func matchKeys(keys, sel map[string]string) bool {
+	for k, v := range sel {
+		if keys[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// LabeledArray is a multi-dimensional array of data blocks. Each dimension is
+// named and labeled by the values found in the data block names along it.
+// Cells without matching data block are nil.
+type LabeledArray struct {
+	Dims   []string     // names of the dimensions
+	Labels [][]string   // sorted labels along each dimension
+	Names  []string     // data block names in row-major order ("" if missing)
+	Data   []*CountData // data blocks in row-major order (nil if missing)
+}
+
+// Shape returns the number of labels along each dimension
+func (a *LabeledArray) Shape() []int {
+	shape := make([]int, len(a.Labels))
+	for i, l := range a.Labels {
+		shape[i] = len(l)
+	}
+	return shape
+}
+
+// Index returns the data block at the provided indices (one per dimension)
+// or nil if the indices are out of range or the cell is empty
+func (a *LabeledArray) Index(idx ...int) *CountData {
+	if len(idx) != len(a.Dims) {
+		return nil
+	}
+	flat := 0
+	for i, j := range idx {
+		if j < 0 || j >= len(a.Labels[i]) {
+			return nil
+		}
+		flat = flat*len(a.Labels[i]) + j
+	}
+	return a.Data[flat]
+}
+
+// At returns the data block with the provided labels (one per dimension) or
+// nil if there is none
+func (a *LabeledArray) At(labels ...string) *CountData {
+	if len(labels) != len(a.Dims) {
+		return nil
+	}
+	idx := make([]int, len(labels))
+	for i, l := range labels {
+		idx[i] = -1
+		for j, v := range a.Labels[i] {
+			if v == l {
+				idx[i] = j
+				break
+			}
+		}
+	}
+	return a.Index(idx...)
+}
+
+// Group selects all data blocks matching the pattern and sel (see
+// SelectByKeys) and arranges them along the requested dimensions. If no
+// dimensions are given, all dimensions of the pattern not fixed by sel are
+// used. Each cell of the resulting array has to correspond to a single data
+// block, i.e. all dimensions varying among the selected data blocks need to
+// be included.
+func (d *MCellData) Group(p *NamePattern, sel map[string]string,
+	dims ...string) (*LabeledArray, error) {
+
+	if len(dims) == 0 {
+		for _, n := range p.Dims() {
+			if _, ok := sel[n]; !ok {
+				dims = append(dims, n)
+			}
+		}
+	}
+	for _, n := range dims {
+		found := false
+		for _, pn := range p.Dims() {
+			found = found || pn == n
+		}
+		if !found {
+			return nil, d.newError(ErrInvalidArgument, "unknown dimension %s", n)
+		}
+	}
+
+	// collect the labels along each dimension
+	names := d.SelectByKeys(p, sel)
+	keys := make([]map[string]string, len(names))
+	labelSets := make([]map[string]bool, len(dims))
+	for i := range labelSets {
+		labelSets[i] = make(map[string]bool)
+	}
+	for i, n := range names {
+		keys[i], _ = p.Parse(n)
+		for j, dim := range dims {
+			labelSets[j][keys[i][dim]] = true
+		}
+	}
+
+	a := &LabeledArray{Dims: dims, Labels: make([][]string, len(dims))}
+	index := make([]map[string]int, len(dims))
+	size := 1
+	for i, set := range labelSets {
+		for l := range set {
+			a.Labels[i] = append(a.Labels[i], l)
+		}
+		sortLabels(a.Labels[i])
+		index[i] = make(map[string]int)
+		for j, l := range a.Labels[i] {
+			index[i][l] = j
+		}
+		size *= len(a.Labels[i])
+	}
+	if len(names) == 0 {
+		size = 0
+	}
+	a.Names = make([]string, size)
+	a.Data = make([]*CountData, size)
+
+	for i, n := range names {
+		flat := 0
+		for j, dim := range dims {
+			flat = flat*len(a.Labels[j]) + index[j][keys[i][dim]]
+		}
+		if a.Names[flat] != "" {
+			return nil, d.newError(ErrIncompatible, "data blocks %s and %s share the same "+
+				"cell, please include all varying dimensions", a.Names[flat], n)
+		}
+		countData, err := d.BlockDataByName(n)
+		if err != nil {
+			return nil, err
+		}
+		a.Names[flat] = n
+		a.Data[flat] = countData
+	}
+	return a, nil
+}
+
+// sortLabels sorts the labels numerically if all of them are numbers and
+// lexically otherwise
+func sortLabels(labels []string) {
+	values := make(map[string]float64)
+	for _, l := range labels {
+		v, err := strconv.ParseFloat(l, 64)
+		if err != nil {
+			sort.Strings(labels)
+			return
+		}
+		values[l] = v
+	}
+	sort.Slice(labels, func(i, j int) bool {
+		if values[labels[i]] == values[labels[j]] {
+			return labels[i] < labels[j]
+		}
+		return values[labels[i]] < values[labels[j]]
+	})
+}
@@ -0,0 +1,154 @@
+package libmbd
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// sensorPattern describes the names of the data blocks of dimsData
+const sensorPattern = `bound_vesicle_(?P<ves>\d+)_(?P<sensor>sensor(?:_Y)?)_(?P<site>\d+)\.dat`
+
+// dimsData returns data whose data blocks are named according to
+// sensorPattern (apart from one) and whose single value is the block ID
+func dimsData(t *testing.T) *MCellData {
+	names := []string{
+		"bound_vesicle_1_sensor_2.dat",
+		"bound_vesicle_1_sensor_10.dat",
+		"bound_vesicle_2_sensor_2.dat",
+		"bound_vesicle_1_sensor_Y_2.dat",
+		"vesicle_1_ca_A01.dat",
+	}
+	var blocks []DataBlock
+	for i, n := range names {
+		blocks = append(blocks, DataBlock{n, &CountData{Col: [][]float64{{float64(i)}},
+			DataTypes: []uint16{IntType}}})
+	}
+	d, err := NewMCellData(blocks, TimeSpec{OutputListType: Step, StepSize: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+// TestCompileNamePattern checks the compilation and matching of name patterns
+func TestCompileNamePattern(t *testing.T) {
+	if _, err := CompileNamePattern(`bound_(\d+`); err == nil {
+		t.Errorf("invalid regular expression was compiled")
+	}
+	if _, err := CompileNamePattern(`bound_(\d+)`); !errors.Is(err, ErrInvalidArgument) {
+		t.Errorf("got error %v for pattern without named groups, want %v", err,
+			ErrInvalidArgument)
+	}
+
+	p, err := CompileNamePattern(sensorPattern)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dims := p.Dims(); !reflect.DeepEqual(dims, []string{"ves", "sensor", "site"}) {
+		t.Errorf("got dimensions %v", dims)
+	}
+
+	tests := []struct {
+		name string
+		keys map[string]string
+	}{
+		{"bound_vesicle_03_sensor_Y_12.dat",
+			map[string]string{"ves": "03", "sensor": "sensor_Y", "site": "12"}},
+		{"bound_vesicle_03_sensor_12.dat",
+			map[string]string{"ves": "03", "sensor": "sensor", "site": "12"}},
+		{"bound_vesicle_03_sensor_12.dat.bak", nil},
+		{"my_bound_vesicle_03_sensor_12.dat", nil},
+		{"bound_vesicle_03_sensor_Z_12.dat", nil},
+	}
+	for _, tt := range tests {
+		keys, ok := p.Parse(tt.name)
+		if ok != (tt.keys != nil) || !reflect.DeepEqual(keys, tt.keys) {
+			t.Errorf("%s: got keys %v (%t), want %v", tt.name, keys, ok, tt.keys)
+		}
+	}
+}
+
+// TestSelectByKeys checks the selection of data blocks by dimension values
+func TestSelectByKeys(t *testing.T) {
+	d := dimsData(t)
+	p := MustCompileNamePattern(sensorPattern)
+	tests := []struct {
+		sel  map[string]string
+		want []string
+	}{
+		{nil, []string{"bound_vesicle_1_sensor_2.dat", "bound_vesicle_1_sensor_10.dat",
+			"bound_vesicle_2_sensor_2.dat", "bound_vesicle_1_sensor_Y_2.dat"}},
+		{map[string]string{"ves": "1", "sensor": "sensor"},
+			[]string{"bound_vesicle_1_sensor_2.dat", "bound_vesicle_1_sensor_10.dat"}},
+		{map[string]string{"site": "2", "sensor": "sensor_Y"},
+			[]string{"bound_vesicle_1_sensor_Y_2.dat"}},
+		{map[string]string{"ves": "01"}, nil},
+		{map[string]string{"pulse": "1"}, nil},
+	}
+	for _, tt := range tests {
+		if got := d.SelectByKeys(p, tt.sel); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%v: got %v, want %v", tt.sel, got, tt.want)
+		}
+	}
+	if n := len(d.ParseNames(p)); n != 4 {
+		t.Errorf("parsed %d instead of 4 data block names", n)
+	}
+}
+
+// TestGroup checks the arrangement of data blocks along dimensions
+func TestGroup(t *testing.T) {
+	d := dimsData(t)
+	p := MustCompileNamePattern(sensorPattern)
+
+	a, err := d.Group(p, map[string]string{"sensor": "sensor"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(a.Dims, []string{"ves", "site"}) ||
+		!reflect.DeepEqual(a.Labels, [][]string{{"1", "2"}, {"2", "10"}}) ||
+		!reflect.DeepEqual(a.Shape(), []int{2, 2}) {
+		t.Fatalf("got dimensions %v with labels %v", a.Dims, a.Labels)
+	}
+	cells := []struct {
+		labels []string
+		want   float64 // ID of the data block or -1 if missing
+	}{
+		{[]string{"1", "2"}, 0},
+		{[]string{"1", "10"}, 1},
+		{[]string{"2", "2"}, 2},
+		{[]string{"2", "10"}, -1},
+		{[]string{"3", "2"}, -1},
+		{[]string{"1"}, -1},
+	}
+	for _, c := range cells {
+		got := a.At(c.labels...)
+		if (got == nil) != (c.want < 0) || (got != nil && got.Col[0][0] != c.want) {
+			t.Errorf("got data block %v at %v, want %g", got, c.labels, c.want)
+		}
+	}
+	if got := a.Index(1, 0); got == nil || got.Col[0][0] != 2 {
+		t.Errorf("got data block %v at index (1, 0)", got)
+	}
+
+	a, err = d.Group(p, map[string]string{"ves": "1", "site": "2"}, "sensor")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(a.Names, []string{"bound_vesicle_1_sensor_2.dat",
+		"bound_vesicle_1_sensor_Y_2.dat"}) {
+		t.Errorf("got data blocks %v", a.Names)
+	}
+
+	a, err = d.Group(p, map[string]string{"ves": "5"})
+	if err != nil || len(a.Data) != 0 {
+		t.Errorf("got %v (%v) for empty selection", a, err)
+	}
+
+	if _, err := d.Group(p, nil, "pulse"); !errors.Is(err, ErrInvalidArgument) {
+		t.Errorf("got error %v for unknown dimension, want %v", err, ErrInvalidArgument)
+	}
+	if _, err := d.Group(p, nil, "ves", "site"); !errors.Is(err, ErrIncompatible) {
+		t.Errorf("got error %v for missing dimension, want %v", err, ErrIncompatible)
+	}
+}
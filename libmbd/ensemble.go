@@ -0,0 +1,459 @@
+package libmbd
+
+import (
+	"math"
+	"regexp"
+)
+
+// DefaultConfidence is the confidence level of the intervals around the
+// ensemble mean used if none is requested
+const DefaultConfidence = 0.95
+
+// EnsembleOptions controls which data blocks an Ensemble accumulates and
+// which statistics it provides
+type EnsembleOptions struct {
+	// Selection is a regular expression selecting the data blocks to
+	// accumulate. All data blocks are accumulated if it is empty.
+	Selection string
+	// Quantiles lists the quantiles within [0, 1] estimated for each data
+	// point. Since each quantile adds a P² sketch to every data point, none
+	// are estimated if it is empty.
+	Quantiles []float64
+	// Confidence is the confidence level of the intervals around the mean.
+	// DefaultConfidence is used if 0.
+	Confidence float64
+	// Grid is a time grid all files are resampled onto via Method before
+	// they are accumulated (see Resample). This allows combining files with
+	// differing output times. If nil, all files need to provide the same
+	// output times.
+	Grid   []float64
+	Method ResampleMethod
+}
+
+// Ensemble accumulates statistics of the same data blocks across many data
+// files, e.g. the output of all seeds of a simulation. Files are added one at
+// a time, hence peak memory use is that of the selected data blocks of a
+// single file plus the accumulators which don't grow with the number of
+// files. For each data block, column, and output time
+// the Ensemble tracks the mean and variance (via Welford's algorithm), the
+// minimum and maximum, and estimates of the requested quantiles (via the P²
+// algorithm). This takes 32 bytes per data point plus 60 bytes per data point
+// and requested quantile.
+// NOTE: All files need to provide the selected data blocks with matching
+// numbers of columns and either the same output times or output times
+// covering the requested time grid.
+type Ensemble struct {
+	regex      *regexp.Regexp
+	quantiles  []float64
+	increments [][5]float64 // P² marker increments of each quantile
+	confidence float64
+	grid       []float64
+	method     ResampleMethod
+
+	n      uint64 // number of files added so far
+	file   string // name of the first file added
+	names  []string
+	times  []float64
+	spec   TimeSpec
+	blocks []ensembleBlock
+}
+
+// ensembleBlock holds the accumulators of a single data block. The cells are
+// stored column by column, the markers of the sketches of all quantiles of a
+// cell are stored contiguously.
+type ensembleBlock struct {
+	numRows   int
+	dataTypes []uint16
+	meta      BlockMeta
+	cells     []cellStats
+	heights   []float64 // P² marker heights
+	positions []int32   // P² marker positions
+}
+
+// cellStats holds the running statistics of a single data point
+type cellStats struct {
+	mean, m2, min, max float64
+}
+
+// EnsembleStats holds the statistics of a data block across all files of an
+// Ensemble. Like CountData.Col, all statistics are indexed by column and row.
+type EnsembleStats struct {
+	Name       string
+	N          uint64    // number of files
+	Times      []float64 // output times of the rows
+	DataTypes  []uint16  // data types of the original columns
+	Meta       BlockMeta
+	Mean       [][]float64
+	Variance   [][]float64 // sample variance, NaN for fewer than two files
+	Min        [][]float64
+	Max        [][]float64
+	Quantiles  []float64     // estimated quantiles
+	Quantile   [][][]float64 // estimates indexed by quantile, column and row
+	Confidence float64       // confidence level of CILow and CIHigh
+	CILow      [][]float64   // lower bound of the confidence interval of Mean
+	CIHigh     [][]float64   // upper bound of the confidence interval of Mean
+}
+
+// NewEnsemble returns an empty Ensemble for the provided options
+func NewEnsemble(opts EnsembleOptions) (*Ensemble, error) {
+	e := &Ensemble{quantiles: opts.Quantiles, confidence: opts.Confidence,
+		grid: opts.Grid, method: opts.Method}
+	if opts.Selection != "" {
+		regex, err := regexp.Compile(opts.Selection)
+		if err != nil {
+			return nil, err
+		}
+		e.regex = regex
+	}
+	for _, p := range e.quantiles {
+		if !(p >= 0 && p <= 1) {
+			return nil, NewError(ErrInvalidArgument, "quantile %g is not within [0, 1]", p)
+		}
+		e.increments = append(e.increments, p2Increments(p))
+	}
+	if e.confidence == 0 {
+		e.confidence = DefaultConfidence
+	}
+	if !(e.confidence > 0 && e.confidence < 1) {
+		return nil, NewError(ErrInvalidArgument, "confidence level %g is not within (0, 1)",
+			e.confidence)
+	}
+	return e, nil
+}
+
+// Add accumulates the selected data blocks of d. The first file determines
+// the data blocks, output times, and column layout all other files are
+// checked against. All selected data blocks of d are decoded and checked
+// before any of them is accumulated, hence the Ensemble is left unchanged if
+// d can't be added.
+func (e *Ensemble) Add(d *MCellData) error {
+	names := e.names
+	if e.n == 0 {
+		var err error
+		if names, err = e.selectNames(d); err != nil {
+			return err
+		}
+	} else if err := e.compatible(d); err != nil {
+		return err
+	}
+
+	blocks := e.blocks
+	if e.n == 0 {
+		blocks = make([]ensembleBlock, len(names))
+	}
+	data := make([]*CountData, len(names))
+	for i, name := range names {
+		countData, err := d.BlockDataByName(name)
+		if err != nil {
+			return err
+		}
+		if e.grid != nil {
+			if countData, err = Resample(countData, d.outputTimes(), e.grid,
+				e.method); err != nil {
+				return WithFile(err, d.fileName)
+			}
+		}
+		if err := blocks[i].check(countData); err != nil {
+			return WithFile(err, d.fileName)
+		}
+		data[i] = countData
+	}
+
+	if e.n == 0 {
+		e.init(d, names)
+	}
+	for i, countData := range data {
+		e.blocks[i].add(countData, e.n+1, e.increments)
+	}
+	e.n++
+	return nil
+}
+
+// selectNames returns the names of the data blocks of d selected by the
+// Ensemble
+func (e *Ensemble) selectNames(d *MCellData) ([]string, error) {
+	var names []string
+	for _, name := range d.blockNames {
+		if e.regex == nil || e.regex.MatchString(name) {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return nil, d.newError(ErrDatasetNotFound, "no data blocks match %s", e.regex)
+	}
+	return names, nil
+}
+
+// init records the selected data blocks and the output times of the first
+// file added to the Ensemble
+func (e *Ensemble) init(d *MCellData, names []string) {
+	e.names = names
+	e.file = d.fileName
+	if e.grid != nil {
+		e.times = e.grid
+		e.spec = gridTimeSpec(e.grid, d.OutputType())
+	} else {
+		e.times = d.OutputTimes()
+		e.spec = d.TimeSpec()
+		if e.spec.TimeList != nil {
+			e.spec.TimeList = e.times
+		}
+	}
+	e.blocks = make([]ensembleBlock, len(e.names))
+}
+
+// compatible checks that d provides the output times (unless the data are
+// resampled) and data blocks of the Ensemble
+func (e *Ensemble) compatible(d *MCellData) error {
+	if e.grid == nil {
+		if err := e.sameTimes(d); err != nil {
+			return err
+		}
+	}
+
+	for i, name := range e.names {
+		id, err := d.BlockNameToID(name)
+		if err != nil {
+			return d.newError(ErrIncompatible, "data block %s is missing", name)
+		}
+		numCols, ok := d.numCols(id)
+		if ok && e.blocks[i].cells != nil && int(numCols) != len(e.blocks[i].dataTypes) {
+			return d.newError(ErrIncompatible, "data block %s has %d instead of %d "+
+				"columns", name, numCols, len(e.blocks[i].dataTypes))
+		}
+	}
+	return nil
+}
+
+// sameTimes checks that d provides the output times of the Ensemble
+func (e *Ensemble) sameTimes(d *MCellData) error {
+	times := d.outputTimes()
+	if len(times) != len(e.times) {
+		return d.newError(ErrIncompatible, "found %d output times but %s has %d",
+			len(times), e.file, len(e.times))
+	}
+	for r, t := range times {
+		if math.Abs(t-e.times[r]) > 1e-9*math.Max(math.Abs(t), math.Abs(e.times[r])) {
+			return d.newError(ErrIncompatible, "output time %g of row %d differs from "+
+				"output time %g of %s", t, r, e.times[r], e.file)
+		}
+	}
+	return nil
+}
+
+// check tests if countData matches the column layout of the data block. The
+// first data accumulated by b determine the layout.
+func (b *ensembleBlock) check(countData *CountData) error {
+	numCols, numRows := len(b.dataTypes), b.numRows
+	if b.cells == nil {
+		numCols, numRows = len(countData.Col), 0
+		if numCols != 0 {
+			numRows = len(countData.Col[0])
+		}
+	}
+	if len(countData.Col) != numCols {
+		return NewError(ErrIncompatible, "data block has %d instead of %d columns",
+			len(countData.Col), numCols)
+	}
+	for c, col := range countData.Col {
+		if len(col) != numRows {
+			return NewError(ErrIncompatible, "column %d has %d instead of %d rows", c,
+				len(col), numRows)
+		}
+	}
+	return nil
+}
+
+// add accumulates countData as the n-th file (1 based). The column layout of
+// countData has to be checked via check beforehand.
+func (b *ensembleBlock) add(countData *CountData, n uint64, increments [][5]float64) {
+	numQ := len(increments)
+	if b.cells == nil {
+		if len(countData.Col) != 0 {
+			b.numRows = len(countData.Col[0])
+		}
+		b.dataTypes = countData.DataTypes
+		b.meta = countData.Meta
+		b.cells = make([]cellStats, len(countData.Col)*b.numRows)
+		b.heights = make([]float64, 5*len(b.cells)*numQ)
+		b.positions = make([]int32, 5*len(b.cells)*numQ)
+	}
+
+	for c, col := range countData.Col {
+		for r, x := range col {
+			i := c*b.numRows + r
+			s := &b.cells[i]
+			if n == 1 {
+				s.min, s.max = x, x
+			}
+			s.min = math.Min(s.min, x)
+			s.max = math.Max(s.max, x)
+			delta := x - s.mean
+			s.mean += delta / float64(n)
+			s.m2 += delta * (x - s.mean)
+
+			for q := range increments {
+				sketch := newP2Sketch(b.heights, b.positions, i*numQ+q)
+				sketch.add(x, n, &increments[q])
+			}
+		}
+	}
+}
+
+// N returns the number of files added to the Ensemble
+func (e *Ensemble) N() uint64 {
+	return e.n
+}
+
+// DataNames returns the names of the accumulated data blocks
+func (e *Ensemble) DataNames() []string {
+	return e.names
+}
+
+// OutputTimes returns the output times common to all files or the time grid
+// all files are resampled onto
+func (e *Ensemble) OutputTimes() []float64 {
+	return e.times
+}
+
+// TimeSpec returns the description of the output times of the Ensemble
+func (e *Ensemble) TimeSpec() TimeSpec {
+	return e.spec
+}
+
+// Stats returns the statistics of the named data block across all files
+// added so far
+func (e *Ensemble) Stats(name string) (*EnsembleStats, error) {
+	id := -1
+	for i, n := range e.names {
+		if n == name {
+			id = i
+			break
+		}
+	}
+	if id < 0 {
+		return nil, NewError(ErrDatasetNotFound, "data block %s is not part of the "+
+			"ensemble", name)
+	}
+
+	b := &e.blocks[id]
+	numCols, numQ := len(b.dataTypes), len(e.quantiles)
+	z := math.Sqrt2 * math.Erfinv(e.confidence)
+	s := &EnsembleStats{
+		Name:       name,
+		N:          e.n,
+		Times:      e.times,
+		DataTypes:  b.dataTypes,
+		Meta:       b.meta,
+		Mean:       newColumns(numCols, b.numRows),
+		Variance:   newColumns(numCols, b.numRows),
+		Min:        newColumns(numCols, b.numRows),
+		Max:        newColumns(numCols, b.numRows),
+		Quantiles:  e.quantiles,
+		Quantile:   make([][][]float64, numQ),
+		Confidence: e.confidence,
+		CILow:      newColumns(numCols, b.numRows),
+		CIHigh:     newColumns(numCols, b.numRows),
+	}
+	for q := range s.Quantile {
+		s.Quantile[q] = newColumns(numCols, b.numRows)
+	}
+
+	for c := 0; c < numCols; c++ {
+		for r := 0; r < b.numRows; r++ {
+			i := c*b.numRows + r
+			cell := b.cells[i]
+			variance := math.NaN()
+			if e.n > 1 {
+				variance = cell.m2 / float64(e.n-1)
+			}
+			s.Mean[c][r] = cell.mean
+			s.Variance[c][r] = variance
+			s.Min[c][r] = cell.min
+			s.Max[c][r] = cell.max
+
+			// NOTE: For the number of files in a typical ensemble the normal
+			// approximation of the distribution of the mean is sufficient
+			delta := z * math.Sqrt(variance/float64(e.n))
+			s.CILow[c][r] = cell.mean - delta
+			s.CIHigh[c][r] = cell.mean + delta
+
+			for q, p := range e.quantiles {
+				sketch := newP2Sketch(b.heights, b.positions, i*numQ+q)
+				s.Quantile[q][c][r] = sketch.value(p, e.n)
+			}
+		}
+	}
+	return s, nil
+}
+
+// StdDev returns the sample standard deviation of each data point
+func (s *EnsembleStats) StdDev() [][]float64 {
+	std := make([][]float64, len(s.Variance))
+	for c, col := range s.Variance {
+		std[c] = make([]float64, len(col))
+		for r, v := range col {
+			std[c][r] = math.Sqrt(v)
+		}
+	}
+	return std
+}
+
+// newColumns returns numCols columns of numRows rows each
+func newColumns(numCols, numRows int) [][]float64 {
+	cols := make([][]float64, numCols)
+	for c := range cols {
+		cols[c] = make([]float64, numRows)
+	}
+	return cols
+}
+
+// StdDevSuffix is appended to the name of a data block to form the name of
+// the data block holding its standard deviation in the output of
+// Ensemble.DataBlocks
+const StdDevSuffix = "__std"
+
+// DataBlocks returns the ensemble mean of each accumulated data block as a
+// data block of the same name, column layout, and metadata holding double
+// data. If withStdDev is set, the standard deviation of each data block is
+// appended as a data block named <name>__std (see StdDevSuffix). Together
+// with TimeSpec this allows writing the ensemble as a binary mcell file
+// (e.g. via WriteAPI2). Unlike Stats, only the mean and standard deviation
+// are computed.
+func (e *Ensemble) DataBlocks(withStdDev bool) ([]DataBlock, error) {
+	var blocks, stdBlocks []DataBlock
+	for i, name := range e.names {
+		b := &e.blocks[i]
+		numCols := len(b.dataTypes)
+		dataTypes := make([]uint16, numCols)
+		for c := range dataTypes {
+			dataTypes[c] = DoubleType
+		}
+
+		mean := newColumns(numCols, b.numRows)
+		var std [][]float64
+		if withStdDev {
+			std = newColumns(numCols, b.numRows)
+		}
+		for c := 0; c < numCols; c++ {
+			for r := 0; r < b.numRows; r++ {
+				cell := b.cells[c*b.numRows+r]
+				mean[c][r] = cell.mean
+				if withStdDev {
+					std[c][r] = math.NaN()
+					if e.n > 1 {
+						std[c][r] = math.Sqrt(cell.m2 / float64(e.n-1))
+					}
+				}
+			}
+		}
+
+		blocks = append(blocks, DataBlock{name, &CountData{Col: mean,
+			DataTypes: dataTypes, Meta: b.meta}})
+		if withStdDev {
+			stdBlocks = append(stdBlocks, DataBlock{name + StdDevSuffix,
+				&CountData{Col: std, DataTypes: dataTypes, Meta: b.meta}})
+		}
+	}
+	return append(blocks, stdBlocks...), nil
+}
@@ -0,0 +1,191 @@
+package libmbd
+
+import (
+	"math"
+	"math/rand"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// ensembleFile returns data with the data blocks "a" and "b" of a single
+// column each holding the provided values
+func ensembleFile(t *testing.T, a, b []float64) *MCellData {
+	d, err := NewMCellData([]DataBlock{
+		{"a", &CountData{Col: [][]float64{a}, DataTypes: []uint16{IntType}}},
+		{"b", &CountData{Col: [][]float64{b}, DataTypes: []uint16{DoubleType}}},
+	}, TimeSpec{OutputListType: Step, StepSize: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+// TestEnsembleKnownValues checks the statistics of a small ensemble against
+// values computed by hand
+func TestEnsembleKnownValues(t *testing.T) {
+	e, err := NewEnsemble(EnsembleOptions{Quantiles: []float64{0.05, 0.25, 0.5}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	values := []float64{4, 2, 5, 4, 4, 9, 7, 5}
+	want := []struct {
+		mean, variance, min, max float64
+		quantiles                []float64
+	}{
+		{4, math.NaN(), 4, 4, []float64{4, 4, 4}},
+		{3, 2, 2, 4, []float64{2.1, 2.5, 3}},
+		{11.0 / 3, 7.0 / 3, 2, 5, []float64{2.2, 3, 4}},
+		{15.0 / 4, 19.0 / 12, 2, 5, []float64{2.3, 3.5, 4}},
+		{19.0 / 5, 6.0 / 5, 2, 5, []float64{2.4, 4, 4}},
+		{14.0 / 3, 246.0 / 45, 2, 9, nil},
+		{5, 16.0 / 3, 2, 9, nil},
+		{5, 32.0 / 7, 2, 9, nil},
+	}
+	for i, x := range values {
+		if err := e.Add(ensembleFile(t, []float64{x}, []float64{-x})); err != nil {
+			t.Fatal(err)
+		}
+		s, err := e.Stats("a")
+		if err != nil {
+			t.Fatal(err)
+		}
+		w := want[i]
+		if s.N != uint64(i+1) || !closeTo(s.Mean[0][0], w.mean) ||
+			!closeTo(s.Variance[0][0], w.variance) || s.Min[0][0] != w.min ||
+			s.Max[0][0] != w.max {
+			t.Errorf("after %d files: got mean %g, variance %g, min %g, max %g, want %+v",
+				i+1, s.Mean[0][0], s.Variance[0][0], s.Min[0][0], s.Max[0][0], w)
+		}
+		for q, v := range w.quantiles {
+			if !closeTo(s.Quantile[q][0][0], v) {
+				t.Errorf("after %d files: got %g quantile %g, want %g", i+1, s.Quantiles[q],
+					s.Quantile[q][0][0], v)
+			}
+		}
+	}
+}
+
+// closeTo tests if a and b agree up to round-off or are both NaN
+func closeTo(a, b float64) bool {
+	if math.IsNaN(a) || math.IsNaN(b) {
+		return math.IsNaN(a) && math.IsNaN(b)
+	}
+	return math.Abs(a-b) <= 1e-12*math.Max(1, math.Abs(b))
+}
+
+// TestEnsembleSeeds checks the statistics of ensembles of normally and
+// exponentially distributed values generated from several seeds against the
+// exact statistics of the samples
+func TestEnsembleSeeds(t *testing.T) {
+	const numFiles = 2000
+	quantiles := []float64{0.1, 0.5, 0.9}
+	for _, seed := range []int64{1, 2, 3, 42, 2015} {
+		rng := rand.New(rand.NewSource(seed))
+		e, err := NewEnsemble(EnsembleOptions{Quantiles: quantiles})
+		if err != nil {
+			t.Fatal(err)
+		}
+		samples := [2][]float64{}
+		for i := 0; i < numFiles; i++ {
+			a, b := 10+2*rng.NormFloat64(), rng.ExpFloat64()
+			samples[0], samples[1] = append(samples[0], a), append(samples[1], b)
+			if err := e.Add(ensembleFile(t, []float64{a}, []float64{b})); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		for i, name := range []string{"a", "b"} {
+			s, err := e.Stats(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			x := samples[i]
+			var mean, m2 float64
+			for _, v := range x {
+				mean += v / numFiles
+			}
+			for _, v := range x {
+				m2 += (v - mean) * (v - mean)
+			}
+			variance := m2 / (numFiles - 1)
+			sort.Float64s(x)
+
+			if math.Abs(s.Mean[0][0]-mean) > 1e-12*math.Abs(mean) ||
+				math.Abs(s.Variance[0][0]-variance) > 1e-10*variance ||
+				s.Min[0][0] != x[0] || s.Max[0][0] != x[numFiles-1] {
+				t.Errorf("seed %d, %s: got mean %g, variance %g, min %g, max %g, want %g, "+
+					"%g, %g, %g", seed, name, s.Mean[0][0], s.Variance[0][0], s.Min[0][0],
+					s.Max[0][0], mean, variance, x[0], x[numFiles-1])
+			}
+
+			// the P² estimates are accurate to a few percent of a standard deviation
+			for q, p := range quantiles {
+				exact := x[int(p*(numFiles-1))]
+				if d := math.Abs(s.Quantile[q][0][0] - exact); d > 0.05*math.Sqrt(variance) {
+					t.Errorf("seed %d, %s: got %g quantile %g, want %g", seed, name, p,
+						s.Quantile[q][0][0], exact)
+				}
+			}
+		}
+	}
+}
+
+// TestEnsembleAddFailure checks that files which can't be added leave the
+// Ensemble unchanged
+func TestEnsembleAddFailure(t *testing.T) {
+	truncated := func(a, b []float64) *MCellData {
+		raw, err := NewRawData([]DataBlock{
+			{"a", &CountData{Col: [][]float64{a}, DataTypes: []uint16{IntType}}},
+			{"b", &CountData{Col: [][]float64{b}, DataTypes: []uint16{DoubleType}}},
+		}, TimeSpec{OutputListType: Step, StepSize: 1})
+		if err != nil {
+			t.Fatal(err)
+		}
+		raw.Buffer = raw.Buffer[:len(raw.Buffer)-1]
+		return FromRaw(raw)
+	}
+	stats := func(e *Ensemble) []*EnsembleStats {
+		var s []*EnsembleStats
+		for _, n := range e.DataNames() {
+			st, err := e.Stats(n)
+			if err != nil {
+				t.Fatal(err)
+			}
+			s = append(s, st)
+		}
+		return s
+	}
+
+	e, err := NewEnsemble(EnsembleOptions{Quantiles: []float64{0.5}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Add(truncated([]float64{1, 2}, []float64{3, 4})); err == nil {
+		t.Fatal("truncated file was added")
+	}
+	if e.N() != 0 || e.DataNames() != nil || e.OutputTimes() != nil {
+		t.Errorf("failed first file initialized the ensemble")
+	}
+
+	for i := 0; i < 7; i++ {
+		x := float64(i)
+		if err := e.Add(ensembleFile(t, []float64{x, 2 * x}, []float64{-x, x})); err != nil {
+			t.Fatal(err)
+		}
+	}
+	before := stats(e)
+
+	for name, d := range map[string]*MCellData{
+		"truncated":    truncated([]float64{1, 2}, []float64{3, 4}),
+		"rows":         ensembleFile(t, []float64{1, 2, 3}, []float64{1, 2, 3}),
+		"missing rows": ensembleFile(t, []float64{1}, []float64{1}),
+	} {
+		if err := e.Add(d); err == nil {
+			t.Errorf("%s: incompatible file was added", name)
+		}
+		if after := stats(e); e.N() != 7 || !reflect.DeepEqual(after, before) {
+			t.Errorf("%s: failed file changed the ensemble", name)
+		}
+	}
+}
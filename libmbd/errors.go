@@ -0,0 +1,79 @@
+package libmbd
+
+import (
+	"errors"
+	"fmt"
+)
+
+// list of error kinds returned by libmbd and the parser packages. Use
+// errors.Is to test for a specific kind and errors.As to retrieve the
+// corresponding *Error with additional context.
+var (
+	ErrEmpty                  = errors.New("empty data file")
+	ErrTruncated              = errors.New("truncated data")
+	ErrCorrupt                = errors.New("corrupt data")
+	ErrUnknownAPI             = errors.New("unknown mcell binary api version")
+	ErrUnknownOutputType      = errors.New("unknown data output type")
+	ErrUnknownDataType        = errors.New("unknown data type")
+	ErrDatasetNotFound        = errors.New("dataset not found")
+	ErrOutOfRange             = errors.New("data block id out of range")
+	ErrUnknownCompression     = errors.New("unknown compression format")
+	ErrUnsupportedCompression = errors.New("unsupported compression format")
+	ErrIncompatible           = errors.New("incompatible data sets")
+	ErrDataTypeMismatch       = errors.New("column has a different data type")
+	ErrInvalidExpression      = errors.New("invalid expression")
+	ErrInvalidArgument        = errors.New("invalid argument")
+)
+
+// Error describes a failure while parsing or accessing mcell binary data. Kind
+// is one of the Err* values above, the remaining fields provide context if
+// available.
+type Error struct {
+	Kind   error  // kind of error
+	File   string // name of the data file or "" if unknown
+	Block  int64  // ID of the affected data block or -1
+	Offset int64  // byte offset into the count data section or -1
+	Msg    string // detailed description, defaults to Kind's message
+	Err    error  // underlying error (if any)
+}
+
+// NewError returns a new *Error of the given kind without block and offset
+// information. The detailed message is assembled according to format.
+func NewError(kind error, format string, a ...interface{}) *Error {
+	return &Error{Kind: kind, Block: -1, Offset: -1, Msg: fmt.Sprintf(format, a...)}
+}
+
+// Error implements the error interface
+func (e *Error) Error() string {
+	msg := e.Msg
+	if msg == "" {
+		msg = e.Kind.Error()
+	}
+	if e.Err != nil {
+		msg = fmt.Sprintf("%s: %s", msg, e.Err)
+	}
+	if e.File != "" {
+		msg = fmt.Sprintf("%s: %s", e.File, msg)
+	}
+	return msg
+}
+
+// Is reports whether target is the kind of the error
+func (e *Error) Is(target error) bool {
+	return target == e.Kind
+}
+
+// Unwrap returns the underlying error
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// WithFile sets the file name of err if it is an *Error without one and
+// returns err
+func WithFile(err error, filename string) error {
+	var e *Error
+	if errors.As(err, &e) && e.File == "" {
+		e.File = filename
+	}
+	return err
+}
@@ -0,0 +1,138 @@
+package libmbd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+)
+
+// sentinels lists all error kinds
+var sentinels = []error{ErrEmpty, ErrTruncated, ErrCorrupt, ErrUnknownAPI,
+	ErrUnknownOutputType, ErrUnknownDataType, ErrDatasetNotFound, ErrOutOfRange,
+	ErrUnknownCompression, ErrUnsupportedCompression, ErrIncompatible,
+	ErrDataTypeMismatch, ErrInvalidExpression, ErrInvalidArgument}
+
+// TestErrorIs checks that an *Error (also if wrapped) matches exactly its own
+// kind as well as its underlying error
+func TestErrorIs(t *testing.T) {
+	for _, kind := range sentinels {
+		t.Run(kind.Error(), func(t *testing.T) {
+			e := NewError(kind, "failure")
+			e.Err = io.ErrUnexpectedEOF
+			for _, err := range []error{e, fmt.Errorf("context: %w", e)} {
+				for _, other := range sentinels {
+					if got := errors.Is(err, other); got != (other == kind) {
+						t.Errorf("errors.Is(%v, %v) = %t", err, other, got)
+					}
+				}
+				if !errors.Is(err, io.ErrUnexpectedEOF) {
+					t.Errorf("%v does not match its underlying error", err)
+				}
+				var target *Error
+				if !errors.As(err, &target) || target != e {
+					t.Errorf("errors.As did not retrieve %v", e)
+				}
+			}
+			if e.Unwrap() != io.ErrUnexpectedEOF || NewError(kind, "").Unwrap() != nil {
+				t.Errorf("Unwrap does not return the underlying error")
+			}
+		})
+	}
+}
+
+// TestErrorMessage checks the assembly of error messages
+func TestErrorMessage(t *testing.T) {
+	tests := []struct {
+		err  *Error
+		want string
+	}{
+		{&Error{Kind: ErrCorrupt}, "corrupt data"},
+		{&Error{Kind: ErrCorrupt, Msg: "bad header"}, "bad header"},
+		{&Error{Kind: ErrCorrupt, Msg: "bad header", Err: io.EOF}, "bad header: EOF"},
+		{&Error{Kind: ErrCorrupt, File: "a.bin", Msg: "bad header", Err: io.EOF},
+			"a.bin: bad header: EOF"},
+	}
+	for _, tt := range tests {
+		if got := tt.err.Error(); got != tt.want {
+			t.Errorf("got message %q, want %q", got, tt.want)
+		}
+	}
+}
+
+// TestWithFile checks that WithFile only adds missing file names to *Error
+func TestWithFile(t *testing.T) {
+	fileOf := func(err error) string {
+		var e *Error
+		if !errors.As(err, &e) {
+			return ""
+		}
+		return e.File
+	}
+
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{"without file", NewError(ErrCorrupt, "failure"), "b.bin"},
+		{"with file", &Error{Kind: ErrCorrupt, File: "a.bin"}, "a.bin"},
+		{"wrapped", fmt.Errorf("context: %w", NewError(ErrCorrupt, "failure")), "b.bin"},
+		{"other", io.EOF, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := WithFile(tt.err, "b.bin")
+			if err != tt.err {
+				t.Errorf("WithFile returned a different error")
+			}
+			if f := fileOf(err); f != tt.want {
+				t.Errorf("got file %q, want %q", f, tt.want)
+			}
+		})
+	}
+}
+
+// TestErrorContext checks that errors of MCellData carry the file name,
+// block ID, and offset of the failure
+func TestErrorContext(t *testing.T) {
+	raw, err := NewRawData([]DataBlock{
+		{"a", &CountData{Col: [][]float64{{1, 2}}, DataTypes: []uint16{IntType}}},
+		{"b", &CountData{Col: [][]float64{{3, 4}}, DataTypes: []uint16{IntType}}},
+	}, TimeSpec{OutputListType: Step, StepSize: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw.FileName = "a.bin"
+	raw.Buffer = raw.Buffer[:len(raw.Buffer)-1]
+	d := FromRaw(raw)
+
+	tests := []struct {
+		name   string
+		err    func() error
+		kind   error
+		block  int64
+		offset int64
+	}{
+		{"out of range", func() error { _, err := d.BlockDataByID(2); return err },
+			ErrOutOfRange, 2, -1},
+		{"not found", func() error { _, err := d.BlockDataByName("c"); return err },
+			ErrDatasetNotFound, -1, -1},
+		{"truncated", func() error { _, err := d.BlockDataByID(1); return err },
+			ErrTruncated, 1, 16},
+		{"validate", func() error { return d.Validate(31)[0] }, ErrTruncated, -1, 31},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var e *Error
+			if err := tt.err(); !errors.As(err, &e) {
+				t.Fatalf("got %v, want *Error", err)
+			}
+			if e.Kind != tt.kind || e.File != "a.bin" || e.Block != tt.block ||
+				e.Offset != tt.offset {
+				t.Errorf("got %+v, want kind %v of block %d at offset %d in a.bin", e,
+					tt.kind, tt.block, tt.offset)
+			}
+		})
+	}
+}
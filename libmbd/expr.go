@@ -0,0 +1,775 @@
+package libmbd
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Expr is a compiled expression deriving a new data set from the data blocks
+// of MCellData. Expressions consist of
+//
+//	numbers           2, 0.5, 1e-3
+//	data block names  bound_A, "name with spaces" or `name`
+//	columns           name[1] (by index) or name[label] (by column label)
+//	arithmetic        +, -, *, / and parentheses
+//	functions         cumsum(x)     cumulative sum over time
+//	                  d/dt(x)       derivative with respect to time
+//	                  window(x, n)  moving average over the last n rows
+//	                  sum(x)        sum of all columns of x
+//	                  sum(/regex/)  sum of all data blocks matching regex
+//
+// Arithmetic is applied element-wise. Operands need to have the same number
+// of columns unless one of them has a single column (or is a number) in which
+// case it is applied to all columns of the other. Integer data remain integer
+// data unless divided or passed to d/dt or window.
+type Expr struct {
+	src  string
+	root exprNode
+}
+
+// exprValue is the result of evaluating (part of) an expression. It is either
+// a number or a set of columns with one value per output time.
+type exprValue struct {
+	number   bool
+	num      float64
+	cols     [][]float64
+	types    []uint16
+	labels   []string // column labels (only for data blocks)
+	fromExpr bool     // whether cols are owned by the expression
+}
+
+// exprContext holds the data an expression is evaluated against
+type exprContext struct {
+	d      *MCellData
+	blocks map[string]*CountData // decoded data blocks
+}
+
+// exprNode is a node of the syntax tree of an expression
+type exprNode interface {
+	eval(c *exprContext) (*exprValue, error)
+}
+
+// token kinds of the expression lexer
+const (
+	tokEOF = iota
+	tokNumber
+	tokName
+	tokRegex
+	tokOp
+)
+
+// token is a lexical token of an expression
+type token struct {
+	kind int
+	text string
+	num  float64
+	pos  int
+}
+
+// ParseExpr compiles the provided expression (see Expr)
+func ParseExpr(src string) (*Expr, error) {
+	toks, err := lexExpr(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &exprParser{toks: toks}
+	root, err := p.parseSum()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokEOF {
+		return nil, exprError(t.pos, "unexpected %q", t.text)
+	}
+	return &Expr{src: src, root: root}, nil
+}
+
+// String returns the source of the expression
+func (e *Expr) String() string {
+	return e.src
+}
+
+// Eval evaluates the expression against the data blocks of d. Only the data
+// blocks referenced by the expression are decoded. The result has one row
+// per output time of d.
+func (e *Expr) Eval(d *MCellData) (*CountData, error) {
+	c := &exprContext{d: d, blocks: make(map[string]*CountData)}
+	v, err := e.root.eval(c)
+	if err != nil {
+		return nil, WithFile(err, d.fileName)
+	}
+	if v.number {
+		v = c.broadcast(v)
+	}
+	return &CountData{Col: v.cols, DataTypes: v.types,
+		Meta: BlockMeta{Info: map[string]string{"expression": e.src}}}, nil
+}
+
+// Eval compiles and evaluates the provided expression against the data
+// blocks of d (see Expr)
+func (d *MCellData) Eval(expr string) (*CountData, error) {
+	e, err := ParseExpr(expr)
+	if err != nil {
+		return nil, err
+	}
+	return e.Eval(d)
+}
+
+// exprError returns an ErrInvalidExpression error at position pos of the
+// expression
+func exprError(pos int, format string, a ...interface{}) *Error {
+	return NewError(ErrInvalidExpression, "invalid expression at position %d: %s", pos+1,
+		fmt.Sprintf(format, a...))
+}
+
+// lexExpr splits the expression into tokens. A / starts a regular expression
+// if an operand is expected and a division otherwise.
+func lexExpr(src string) ([]token, error) {
+	var toks []token
+	operand := true
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n':
+			i++
+			continue
+
+		case isDigit(c) || (c == '.' && i+1 < len(src) && isDigit(src[i+1])):
+			j := i
+			for j < len(src) && (isDigit(src[j]) || src[j] == '.') {
+				j++
+			}
+			if j < len(src) && (src[j] == 'e' || src[j] == 'E') {
+				k := j + 1
+				if k < len(src) && (src[k] == '+' || src[k] == '-') {
+					k++
+				}
+				if k < len(src) && isDigit(src[k]) {
+					for j = k; j < len(src) && isDigit(src[j]); j++ {
+					}
+				}
+			}
+			num, err := strconv.ParseFloat(src[i:j], 64)
+			if err != nil {
+				return nil, exprError(i, "invalid number %s", src[i:j])
+			}
+			toks = append(toks, token{tokNumber, src[i:j], num, i})
+			i = j
+
+		case isNameStart(c):
+			j := i
+			for j < len(src) && (isNameStart(src[j]) || isDigit(src[j]) || src[j] == '.') {
+				j++
+			}
+			toks = append(toks, token{kind: tokName, text: src[i:j], pos: i})
+			i = j
+
+		case c == '"' || c == '`':
+			j := strings.IndexByte(src[i+1:], c)
+			if j < 0 {
+				return nil, exprError(i, "unterminated name")
+			}
+			toks = append(toks, token{kind: tokName, text: src[i+1 : i+1+j], pos: i})
+			i += j + 2
+
+		case c == '/' && operand:
+			// the closing / may be escaped as \/ within the regular expression
+			var re strings.Builder
+			j := i + 1
+			for ; j < len(src) && src[j] != '/'; j++ {
+				if src[j] == '\\' && j+1 < len(src) && src[j+1] == '/' {
+					j++
+				}
+				re.WriteByte(src[j])
+			}
+			if j == len(src) {
+				return nil, exprError(i, "unterminated regular expression")
+			}
+			toks = append(toks, token{kind: tokRegex, text: re.String(), pos: i})
+			i = j + 1
+
+		case strings.IndexByte("+-*/()[],", c) >= 0:
+			toks = append(toks, token{kind: tokOp, text: src[i : i+1], pos: i})
+			i++
+			operand = c != ')' && c != ']'
+			continue
+
+		default:
+			return nil, exprError(i, "unexpected character %q", c)
+		}
+		operand = false
+	}
+	return append(toks, token{kind: tokEOF, text: "end of expression", pos: len(src)}),
+		nil
+}
+
+// isDigit tests if c is a decimal digit
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// isNameStart tests if c can start an unquoted data block name
+func isNameStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+// IsIdentifier tests if s can be used as an unquoted data block name within
+// an expression, i.e. if it starts with a letter or underscore followed by
+// letters, digits, underscores, or dots
+func IsIdentifier(s string) bool {
+	if s == "" || !isNameStart(s[0]) {
+		return false
+	}
+	for i := 1; i < len(s); i++ {
+		if !isNameStart(s[i]) && !isDigit(s[i]) && s[i] != '.' {
+			return false
+		}
+	}
+	return true
+}
+
+// isInt tests if x is integral and within the range of int64
+func isInt(x float64) bool {
+	return x == math.Trunc(x) && x >= math.MinInt64 && x < -math.MinInt64
+}
+
+// exprParser is a recursive descent parser for expressions according to
+//
+//	sum     = product { ("+" | "-") product }
+//	product = unary { ("*" | "/") unary }
+//	unary   = ("-" | "+") unary | primary [ "[" index "]" ]
+//	primary = number | name | name "(" args ")" | "d/dt" "(" sum ")" | "(" sum ")"
+//	args    = (sum | regex) { "," (sum | regex) }
+type exprParser struct {
+	toks []token
+	pos  int
+}
+
+// peek returns the next token without consuming it
+func (p *exprParser) peek() token {
+	return p.toks[p.pos]
+}
+
+// next consumes and returns the next token
+func (p *exprParser) next() token {
+	t := p.toks[p.pos]
+	if t.kind != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+// isOp tests if the token at offset i from the current one is operator op
+func (p *exprParser) isOp(i int, op string) bool {
+	if p.pos+i >= len(p.toks) {
+		return false
+	}
+	t := p.toks[p.pos+i]
+	return t.kind == tokOp && t.text == op
+}
+
+// expect consumes the next token which has to be operator op
+func (p *exprParser) expect(op string) error {
+	if t := p.next(); t.kind != tokOp || t.text != op {
+		return exprError(t.pos, "expected %q but found %q", op, t.text)
+	}
+	return nil
+}
+
+// parseSum parses a sum or difference of products
+func (p *exprParser) parseSum() (exprNode, error) {
+	x, err := p.parseProduct()
+	if err != nil {
+		return nil, err
+	}
+	for p.isOp(0, "+") || p.isOp(0, "-") {
+		t := p.next()
+		y, err := p.parseProduct()
+		if err != nil {
+			return nil, err
+		}
+		x = &binaryNode{t.text[0], x, y, t.pos}
+	}
+	return x, nil
+}
+
+// parseProduct parses a product or quotient of unary expressions
+func (p *exprParser) parseProduct() (exprNode, error) {
+	x, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.isOp(0, "*") || p.isOp(0, "/") {
+		t := p.next()
+		y, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		x = &binaryNode{t.text[0], x, y, t.pos}
+	}
+	return x, nil
+}
+
+// parseUnary parses a signed or indexed primary expression
+func (p *exprParser) parseUnary() (exprNode, error) {
+	if p.isOp(0, "-") || p.isOp(0, "+") {
+		t := p.next()
+		x, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		if t.text == "+" {
+			return x, nil
+		}
+		return &binaryNode{'*', numberNode(-1), x, t.pos}, nil
+	}
+
+	x, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	for p.isOp(0, "[") {
+		p.next()
+		t := p.next()
+		idx := &indexNode{x: x, col: -1, pos: t.pos}
+		switch {
+		case t.kind == tokNumber && isInt(t.num) && t.num >= 0:
+			idx.col = int(t.num)
+		case t.kind == tokName:
+			idx.label = t.text
+		default:
+			return nil, exprError(t.pos, "invalid column %q", t.text)
+		}
+		if err := p.expect("]"); err != nil {
+			return nil, err
+		}
+		x = idx
+	}
+	return x, nil
+}
+
+// parsePrimary parses numbers, data block names, function calls, and
+// parenthesized expressions
+func (p *exprParser) parsePrimary() (exprNode, error) {
+	t := p.next()
+	switch {
+	case t.kind == tokNumber:
+		return numberNode(t.num), nil
+
+	case t.kind == tokName && t.text == "d" && p.isOp(0, "/") && p.isOp(2, "("):
+		if dt := p.toks[p.pos+1]; dt.kind == tokName && dt.text == "dt" {
+			p.next()
+			p.next()
+			return p.parseCall("d/dt", t.pos)
+		}
+		return blockNode(t.text), nil
+
+	case t.kind == tokName && p.isOp(0, "("):
+		return p.parseCall(t.text, t.pos)
+
+	case t.kind == tokName:
+		return blockNode(t.text), nil
+
+	case t.kind == tokRegex:
+		re, err := regexp.Compile(t.text)
+		if err != nil {
+			return nil, exprError(t.pos, "%s", err)
+		}
+		return &regexNode{re, t.pos}, nil
+
+	case t.kind == tokOp && t.text == "(":
+		x, err := p.parseSum()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(")"); err != nil {
+			return nil, err
+		}
+		return x, nil
+	}
+	return nil, exprError(t.pos, "unexpected %q", t.text)
+}
+
+// parseCall parses the arguments of a call of function name
+func (p *exprParser) parseCall(name string, pos int) (exprNode, error) {
+	f, ok := exprFuncs[name]
+	if !ok {
+		return nil, exprError(pos, "unknown function %s", name)
+	}
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+
+	call := &callNode{name: name, pos: pos}
+	for !p.isOp(0, ")") {
+		if len(call.args) > 0 {
+			if err := p.expect(","); err != nil {
+				return nil, err
+			}
+		}
+		x, err := p.parseSum()
+		if err != nil {
+			return nil, err
+		}
+		call.args = append(call.args, x)
+	}
+	p.next()
+
+	if len(call.args) != f.numArgs {
+		return nil, exprError(pos, "%s expects %d argument(s) but got %d", name,
+			f.numArgs, len(call.args))
+	}
+	return call, nil
+}
+
+// numberNode is a numeric constant
+type numberNode float64
+
+// eval implements exprNode
+func (n numberNode) eval(c *exprContext) (*exprValue, error) {
+	return &exprValue{number: true, num: float64(n)}, nil
+}
+
+// blockNode refers to all columns of a data block
+type blockNode string
+
+// eval implements exprNode
+func (n blockNode) eval(c *exprContext) (*exprValue, error) {
+	data, err := c.block(string(n))
+	if err != nil {
+		return nil, err
+	}
+	return &exprValue{cols: data.Col, types: data.DataTypes, labels: data.Meta.Labels},
+		nil
+}
+
+// indexNode selects a single column of its operand either by index or by
+// label
+type indexNode struct {
+	x     exprNode
+	col   int
+	label string
+	pos   int
+}
+
+// eval implements exprNode
+func (n *indexNode) eval(c *exprContext) (*exprValue, error) {
+	x, err := n.x.eval(c)
+	if err != nil {
+		return nil, err
+	}
+	if x.number {
+		return nil, exprError(n.pos, "can not select a column of a number")
+	}
+
+	col := n.col
+	if n.label != "" {
+		for i, l := range x.labels {
+			if l == n.label {
+				col = i
+				break
+			}
+		}
+		if col < 0 {
+			return nil, exprError(n.pos, "unknown column label %s", n.label)
+		}
+	}
+	if col >= len(x.cols) {
+		return nil, exprError(n.pos, "column %d is out of range for %d column(s)", col,
+			len(x.cols))
+	}
+	return &exprValue{cols: x.cols[col : col+1], types: x.types[col : col+1],
+		fromExpr: x.fromExpr}, nil
+}
+
+// regexNode is a regular expression selecting data blocks by name
+type regexNode struct {
+	re  *regexp.Regexp
+	pos int
+}
+
+// eval implements exprNode
+func (n *regexNode) eval(c *exprContext) (*exprValue, error) {
+	return nil, exprError(n.pos, "regular expressions are only supported by sum")
+}
+
+// binaryNode applies an arithmetic operator to its operands
+type binaryNode struct {
+	op   byte
+	x, y exprNode
+	pos  int
+}
+
+// eval implements exprNode
+func (n *binaryNode) eval(c *exprContext) (*exprValue, error) {
+	x, err := n.x.eval(c)
+	if err != nil {
+		return nil, err
+	}
+	y, err := n.y.eval(c)
+	if err != nil {
+		return nil, err
+	}
+
+	var op func(a, b float64) float64
+	switch n.op {
+	case '+':
+		op = func(a, b float64) float64 { return a + b }
+	case '-':
+		op = func(a, b float64) float64 { return a - b }
+	case '*':
+		op = func(a, b float64) float64 { return a * b }
+	case '/':
+		op = func(a, b float64) float64 { return a / b }
+	}
+	if x.number && y.number {
+		return &exprValue{number: true, num: op(x.num, y.num)}, nil
+	}
+	if x.number {
+		x = c.broadcast(x)
+	}
+	if y.number {
+		y = c.broadcast(y)
+	}
+
+	numCols := len(x.cols)
+	if len(y.cols) > numCols {
+		numCols = len(y.cols)
+	}
+	if (len(x.cols) != numCols && len(x.cols) != 1) ||
+		(len(y.cols) != numCols && len(y.cols) != 1) {
+		return nil, exprError(n.pos, "can not combine %d with %d column(s)", len(x.cols),
+			len(y.cols))
+	}
+
+	v := c.newValue(numCols)
+	for i := range v.cols {
+		a, b := x.cols[i%len(x.cols)], y.cols[i%len(y.cols)]
+		if x.types[i%len(x.types)] == IntType && y.types[i%len(y.types)] == IntType &&
+			n.op != '/' {
+			v.types[i] = IntType
+		}
+		for r := range v.cols[i] {
+			v.cols[i][r] = op(a[r], b[r])
+		}
+	}
+	return v, nil
+}
+
+// callNode calls a function
+type callNode struct {
+	name string
+	args []exprNode
+	pos  int
+}
+
+// exprFunc describes a function available within expressions
+type exprFunc struct {
+	numArgs int
+	eval    func(c *exprContext, n *callNode) (*exprValue, error)
+}
+
+// exprFuncs lists the functions available within expressions
+var exprFuncs = map[string]exprFunc{
+	"cumsum": {1, evalCumSum},
+	"d/dt":   {1, evalDerivative},
+	"window": {2, evalWindow},
+	"sum":    {1, evalSum},
+}
+
+// eval implements exprNode
+func (n *callNode) eval(c *exprContext) (*exprValue, error) {
+	return exprFuncs[n.name].eval(c, n)
+}
+
+// columns evaluates argument i of the call which has to yield columns. The
+// returned value may be modified.
+func (n *callNode) columns(c *exprContext, i int) (*exprValue, error) {
+	x, err := n.args[i].eval(c)
+	if err != nil {
+		return nil, err
+	}
+	if x.number {
+		return nil, exprError(n.pos, "%s expects data columns but got a number", n.name)
+	}
+	if !x.fromExpr {
+		v := c.newValue(len(x.cols))
+		for j := range x.cols {
+			copy(v.cols[j], x.cols[j])
+		}
+		copy(v.types, x.types)
+		x = v
+	}
+	return x, nil
+}
+
+// evalCumSum computes the cumulative sum of each column over time
+func evalCumSum(c *exprContext, n *callNode) (*exprValue, error) {
+	x, err := n.columns(c, 0)
+	if err != nil {
+		return nil, err
+	}
+	for _, col := range x.cols {
+		for r := 1; r < len(col); r++ {
+			col[r] += col[r-1]
+		}
+	}
+	return x, nil
+}
+
+// evalDerivative computes the derivative of each column with respect to time
+// via central differences in the interior and one-sided differences at both
+// ends
+func evalDerivative(c *exprContext, n *callNode) (*exprValue, error) {
+	x, err := n.args[0].eval(c)
+	if err != nil {
+		return nil, err
+	}
+	if x.number {
+		x = c.broadcast(x)
+	}
+
+	times := c.d.outputTimes()
+	v := c.newValue(len(x.cols))
+	for i, col := range x.cols {
+		if len(col) < 2 {
+			continue
+		}
+		for r := range col {
+			lo, hi := r-1, r+1
+			if lo < 0 {
+				lo = 0
+			}
+			if hi >= len(col) {
+				hi = len(col) - 1
+			}
+			v.cols[i][r] = (col[hi] - col[lo]) / (times[hi] - times[lo])
+		}
+	}
+	return v, nil
+}
+
+// evalWindow computes the moving average of each column over the last n rows
+// (including the current one). The first rows are averaged over the available
+// rows.
+func evalWindow(c *exprContext, n *callNode) (*exprValue, error) {
+	x, err := n.columns(c, 0)
+	if err != nil {
+		return nil, err
+	}
+	w, err := n.args[1].eval(c)
+	if err != nil {
+		return nil, err
+	}
+	if !w.number || w.num < 1 || !isInt(w.num) {
+		return nil, exprError(n.pos, "window size has to be a positive integer")
+	}
+	size := int(w.num)
+
+	for i, col := range x.cols {
+		var sum float64
+		orig := append([]float64(nil), col...)
+		for r := range col {
+			sum += orig[r]
+			if r >= size {
+				sum -= orig[r-size]
+			}
+			col[r] = sum / math.Min(float64(r+1), float64(size))
+		}
+		x.types[i] = DoubleType
+	}
+	return x, nil
+}
+
+// evalSum computes either the sum of all columns of its argument or the sum
+// of all data blocks matching a regular expression
+func evalSum(c *exprContext, n *callNode) (*exprValue, error) {
+	re, ok := n.args[0].(*regexNode)
+	if !ok {
+		x, err := n.columns(c, 0)
+		if err != nil {
+			return nil, err
+		}
+		v := c.newValue(1)
+		v.types[0] = IntType
+		for i, col := range x.cols {
+			for r, val := range col {
+				v.cols[0][r] += val
+			}
+			if x.types[i] != IntType {
+				v.types[0] = DoubleType
+			}
+		}
+		return v, nil
+	}
+
+	var v *exprValue
+	for _, name := range c.d.blockNames {
+		if !re.re.MatchString(name) {
+			continue
+		}
+		data, err := c.block(name)
+		if err != nil {
+			return nil, err
+		}
+		if v == nil {
+			v = c.newValue(len(data.Col))
+			copy(v.types, data.DataTypes)
+		}
+		if len(data.Col) != len(v.cols) {
+			return nil, exprError(n.pos, "data block %s has %d instead of %d column(s)",
+				name, len(data.Col), len(v.cols))
+		}
+		for i, col := range data.Col {
+			for r, val := range col {
+				v.cols[i][r] += val
+			}
+			if data.DataTypes[i] != IntType {
+				v.types[i] = DoubleType
+			}
+		}
+	}
+	if v == nil {
+		return nil, exprError(re.pos, "no data blocks match %s", re.re)
+	}
+	return v, nil
+}
+
+// block returns the named data block decoding it on first use
+func (c *exprContext) block(name string) (*CountData, error) {
+	if data, ok := c.blocks[name]; ok {
+		return data, nil
+	}
+	data, err := c.d.BlockDataByName(name)
+	if err != nil {
+		return nil, err
+	}
+	c.blocks[name] = data
+	return data, nil
+}
+
+// newValue returns a value of numCols zero initialized double columns with
+// one row per output time
+func (c *exprContext) newValue(numCols int) *exprValue {
+	v := &exprValue{cols: newColumns(numCols, int(c.d.BlockLen())),
+		types: make([]uint16, numCols), fromExpr: true}
+	for i := range v.types {
+		v.types[i] = DoubleType
+	}
+	return v
+}
+
+// broadcast turns a number into a single column holding the number in each
+// row. Integral numbers are treated as integer data.
+func (c *exprContext) broadcast(x *exprValue) *exprValue {
+	v := c.newValue(1)
+	for r := range v.cols[0] {
+		v.cols[0][r] = x.num
+	}
+	if isInt(x.num) {
+		v.types[0] = IntType
+	}
+	return v
+}
@@ -0,0 +1,49 @@
+package libmbd
+
+import (
+	"math"
+	"testing"
+)
+
+// TestEvalNumberTypes checks that only finite integral numbers within the
+// range of int64 are broadcast as integer data
+func TestEvalNumberTypes(t *testing.T) {
+	d := testData(t, "a")
+	tests := []struct {
+		expr string
+		typ  uint16
+	}{
+		{"3", IntType},
+		{"-4", IntType},
+		{"0.5", DoubleType},
+		{"1/0", DoubleType},
+		{"-1/0", DoubleType},
+		{"1e300", DoubleType},
+	}
+	for _, tt := range tests {
+		c, err := d.Eval(tt.expr)
+		if err != nil {
+			t.Fatalf("%s: %s", tt.expr, err)
+		}
+		if c.DataTypes[0] != tt.typ {
+			t.Errorf("%s: got data type %d, want %d", tt.expr, c.DataTypes[0], tt.typ)
+		}
+	}
+
+	c, err := d.Eval("1/0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !math.IsInf(c.Col[0][0], 1) {
+		t.Errorf("1/0: got %g, want +Inf", c.Col[0][0])
+	}
+}
+
+func TestIsIdentifier(t *testing.T) {
+	for s, want := range map[string]bool{"ca": true, "bound_A.1": true, "_x2": true,
+		"": false, "2x": false, "sum(/a": false, `"ca"`: false, "a b": false} {
+		if got := IsIdentifier(s); got != want {
+			t.Errorf("%q: got %t, want %t", s, got, want)
+		}
+	}
+}
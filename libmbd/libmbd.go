@@ -3,8 +3,11 @@
 package libmbd
 
 import (
-	"fmt"
+	"io"
+	"math"
 	"regexp"
+	"sort"
+	"sync"
 
 	"github.com/haskelladdict/mbdr/parser/util"
 )
@@ -16,18 +19,35 @@ const (
 	IterationListType
 )
 
-// list of currently know API versions
+// data types of API version 2 data columns (as defined by MCell's
+// count_type_t). Independent of their type, all values are stored as doubles.
+// The data types of all other formats are reported using these constants.
 const (
-	API1 = "MCELL_BINARY_API_1"
-	API2 = "MCELL_BINARY_API_2"
+	DoubleType uint16 = iota
+	IntType
 )
 
-// MCellData tracks the data contained in the binary mcell file as well as
-// relevant metadata to retrieve specific data items.
+// list of currently know API versions. ASCII denotes data read from MCell
+// ASCII reaction data output which are held in the layout of API2.
+const (
+	API1  = "MCELL_BINARY_API_1"
+	API2  = "MCELL_BINARY_API_2"
+	ASCII = "MCELL_ASCII"
+)
+
+// RawData holds the header information and count data of binary mcell data
+// while they are being parsed or assembled. Parsers fill in RawData and turn
+// it into MCellData via FromRaw.
 // NOTE: Depending on the API version of the binary output data not all fields
 // are defined
-type MCellData struct {
+// NOTE: The count data are either held in Buffer or, if Buffer is nil, read
+// on demand from Source. In the latter case only the parts of the data
+// needed for a requested data block are ever decoded. If both are set, Buffer
+// is backed by Source (e.g. via a memory mapping) and must not be used after
+// calling Close.
+type RawData struct {
 	Buffer         util.ReadBuf
+	Source         io.ReaderAt
 	OutputListType uint16
 	BlockSize      uint64
 	StepSize       float64
@@ -36,10 +56,73 @@ type MCellData struct {
 	BlockNames     []string
 	BlockNameMap   map[string]uint64
 	API            string
+	FileName       string      // name of the underlying data file (if any)
+	Meta           []BlockMeta // metadata of each data block (if any)
 	API1Data
 	API2Data
 }
 
+// MCellData provides access to the data contained in a binary mcell file as
+// well as relevant metadata to retrieve specific data items.
+// NOTE: MCellData is immutable apart from its metadata. Its state can only be
+// set via FromRaw and all accessors return copies of it or decode count data
+// into freshly allocated CountData. The metadata can be replaced via SetMeta
+// which is synchronized with all accessors. Hence a single MCellData can be
+// shared by any number of goroutines, e.g. to decode different data blocks in
+// parallel. Close must only be called once all goroutines are done.
+type MCellData struct {
+	buffer         util.ReadBuf
+	source         io.ReaderAt
+	outputListType uint16
+	blockSize      uint64
+	stepSize       float64
+	timeList       []float64
+	numBlocks      uint64
+	blockNames     []string
+	blockNameMap   map[string]uint64
+	api            string
+	fileName       string
+
+	// API1 data
+	offset       uint64
+	blockEntries []BlockEntry
+
+	// API2 data
+	outputBufSize uint64
+	totalNumCols  uint64
+	blockInfo     []BlockData
+
+	metaMu sync.RWMutex // guards meta
+	meta   []BlockMeta
+
+	timesOnce sync.Once // guards the computation of stepTimes
+	stepTimes []float64 // output times of STEP data computed on first request
+}
+
+// FromRaw returns MCellData providing access to the raw data. MCellData takes
+// ownership of raw which must not be modified afterwards.
+func FromRaw(raw *RawData) *MCellData {
+	return &MCellData{
+		buffer:         raw.Buffer,
+		source:         raw.Source,
+		outputListType: raw.OutputListType,
+		blockSize:      raw.BlockSize,
+		stepSize:       raw.StepSize,
+		timeList:       raw.TimeList,
+		numBlocks:      raw.NumBlocks,
+		blockNames:     raw.BlockNames,
+		blockNameMap:   raw.BlockNameMap,
+		api:            raw.API,
+		fileName:       raw.FileName,
+		offset:         raw.Offset,
+		blockEntries:   raw.BlockEntries,
+		outputBufSize:  raw.OutputBufSize,
+		totalNumCols:   raw.TotalNumCols,
+		blockInfo:      raw.BlockInfo,
+		meta:           raw.Meta,
+	}
+}
+
 // API1Data are data items specific to API version 1 of the mcell binary output
 // format.
 type API1Data struct {
@@ -75,57 +158,152 @@ type BlockData struct {
 }
 
 // CountData is a container holding the data corresponding to a reaction data
-// output block consisting of a number of columns
+// output block consisting of a number of columns. DataTypes holds the IntType
+// or DoubleType of each column independent of the API version of the data.
 type CountData struct {
 	Col       [][]float64
 	DataTypes []uint16
+	Meta      BlockMeta
 }
 
-// DataNames returns the list of available blocknames
+// API returns the API version of the data (see API1, API2, and ASCII)
+func (d *MCellData) API() string {
+	return d.api
+}
+
+// FileName returns the name of the underlying data file or "" if unknown
+func (d *MCellData) FileName() string {
+	return d.fileName
+}
+
+// External tests if the count data are provided by an external source such
+// as a memory mapping or the data file itself instead of being held in memory
+// allocated by the Go runtime. The former are only released by Close.
+func (d *MCellData) External() bool {
+	return d.source != nil
+}
+
+// Close releases the resources held by the data source of MCellData (if any)
+func (d *MCellData) Close() error {
+	if c, ok := d.source.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// dataAt returns length bytes of count data of data block id starting at
+// offset loc either directly from the data buffer or from the data source
+func (d *MCellData) dataAt(id, loc, length uint64) (util.ReadBuf, error) {
+	if d.buffer == nil && d.source != nil {
+		if length == 0 {
+			return nil, nil
+		}
+		buf := make(util.ReadBuf, length)
+		if _, err := d.source.ReadAt(buf, int64(loc)); err != nil {
+			e := d.blockError(ErrTruncated, id,
+				"truncated data detected - output file may be corrupt")
+			e.Offset = int64(loc)
+			if err != io.EOF {
+				e.Kind, e.Msg, e.Err = ErrCorrupt, "failed to read count data", err
+			}
+			return nil, e
+		}
+		return buf, nil
+	}
+
+	if loc+length > uint64(len(d.buffer)) {
+		e := d.blockError(ErrTruncated, id,
+			"truncated data detected - output file may be corrupt")
+		e.Offset = int64(loc)
+		return nil, e
+	}
+	return d.buffer[loc : loc+length], nil
+}
+
+// newError returns a new *Error of the given kind for the underlying data file
+func (d *MCellData) newError(kind error, format string, a ...interface{}) *Error {
+	e := NewError(kind, format, a...)
+	e.File = d.fileName
+	return e
+}
+
+// blockError returns a new *Error of the given kind for data block id
+func (d *MCellData) blockError(kind error, id uint64, format string,
+	a ...interface{}) *Error {
+	e := d.newError(kind, format, a...)
+	e.Block = int64(id)
+	return e
+}
+
+// DataNames returns a copy of the list of available blocknames
 func (d *MCellData) DataNames() []string {
-	return d.BlockNames
+	return append([]string(nil), d.blockNames...)
 }
 
 // IDtoBlockName returns the blockname corresponding to the given id
 func (d *MCellData) IDtoBlockName(id uint64) (string, error) {
-	if id < 0 || id >= uint64(len(d.BlockNames)) {
-		return "", fmt.Errorf("requested id is out of range")
+	if id < 0 || id >= uint64(len(d.blockNames)) {
+		return "", d.blockError(ErrOutOfRange, id, "requested id is out of range")
 	}
-	return d.BlockNames[id], nil
+	return d.blockNames[id], nil
+}
+
+// BlockNameToID returns the ID of the data block with the given name
+func (d *MCellData) BlockNameToID(name string) (uint64, error) {
+	id, ok := d.blockNameMap[name]
+	if !ok {
+		return 0, d.newError(ErrDatasetNotFound, "dataset %s not found", name)
+	}
+	return id, nil
 }
 
 // NumDataBlocks returns the number of available datablocks
 func (d *MCellData) NumDataBlocks() uint64 {
-	return d.NumBlocks
+	return d.numBlocks
 }
 
 // BlockLen returns the number of output iterations per datablock
 func (d *MCellData) BlockLen() uint64 {
-	return d.BlockSize
+	return d.blockSize
 }
 
 // OutputType returns the output type (STEP, ITERATION_LIST/TIME_LIST)
 func (d *MCellData) OutputType() uint16 {
-	return d.OutputListType
+	return d.outputListType
 }
 
 // OutputStepLen returns the output step length. NOTE: The returns value is only
 // meaningful is OutputListType == Step, otherwise this function returns 0
 func (d *MCellData) OutputStepLen() float64 {
-	return d.StepSize
+	return d.stepSize
+}
+
+// OutputBufSize returns the number of rows per stream block of API2 data or
+// 0 if the data are not stored in stream blocks
+func (d *MCellData) OutputBufSize() uint64 {
+	return d.outputBufSize
 }
 
 // OutputTimes returns a slice with the output times corresponding to the
-// column data (either computed from STEP or via ITERATION_LIST/TIME_LIST)
-// NOTE: In the case of STEP we cache the timelist after the first request
+// column data (either computed from STEP or via ITERATION_LIST/TIME_LIST).
+// The returned slice is a copy which may be modified by the caller.
 func (d *MCellData) OutputTimes() []float64 {
-	if d.OutputType() == Step && len(d.TimeList) == 0 {
-		d.TimeList = make([]float64, d.BlockLen())
-		for i := uint64(0); i < d.BlockLen(); i++ {
-			d.TimeList[i] = d.OutputStepLen() * float64(i)
-		}
+	return append([]float64(nil), d.outputTimes()...)
+}
+
+// outputTimes returns the output times shared by all callers
+// NOTE: In the case of STEP we cache the output times after the first request.
+func (d *MCellData) outputTimes() []float64 {
+	if d.OutputType() != Step || len(d.timeList) != 0 {
+		return d.timeList
 	}
-	return d.TimeList
+	d.timesOnce.Do(func() {
+		d.stepTimes = make([]float64, d.BlockLen())
+		for i := range d.stepTimes {
+			d.stepTimes[i] = d.OutputStepLen() * float64(i)
+		}
+	})
+	return d.stepTimes
 }
 
 // BlockDataByRegex returns a map with all datasets whose name matched the
@@ -139,7 +317,7 @@ func (d *MCellData) BlockDataByRegex(selection string) (map[string]*CountData, e
 	}
 
 	outputData := make(map[string]*CountData)
-	names := d.DataNames()
+	names := d.blockNames
 	for _, n := range names {
 		if regex.MatchString(n) {
 			countData, err := d.BlockDataByName(n)
@@ -155,9 +333,9 @@ func (d *MCellData) BlockDataByRegex(selection string) (map[string]*CountData, e
 // BlockDataByName returns the data stored in the data block of the given name
 // as a CountData struct
 func (d *MCellData) BlockDataByName(name string) (*CountData, error) {
-	id, ok := d.BlockNameMap[name]
-	if !ok {
-		return nil, fmt.Errorf("dataset %s not found", name)
+	id, err := d.BlockNameToID(name)
+	if err != nil {
+		return nil, err
 	}
 
 	return d.BlockDataByID(id)
@@ -165,122 +343,193 @@ func (d *MCellData) BlockDataByName(name string) (*CountData, error) {
 
 // BlockDataByID returns the data stored in the data block of the given ID
 // as a CountData struct
-// NOTE: This is the only method of MCellData which is API sensitive
 func (d *MCellData) BlockDataByID(id uint64) (*CountData, error) {
-	if id < 0 || id >= d.NumBlocks {
-		return nil, fmt.Errorf("supplied data ID %d is out of range", id)
+	return d.BlockDataByIDRows(id, 0, d.blockSize, 1)
+}
+
+// BlockDataByNameRange returns the rows of the named data block whose output
+// times t satisfy tStart <= t <= tEnd as a CountData struct. Use
+// RowsInTimeRange to determine the corresponding output times.
+func (d *MCellData) BlockDataByNameRange(name string, tStart, tEnd float64) (*CountData,
+	error) {
+	id, err := d.BlockNameToID(name)
+	if err != nil {
+		return nil, err
+	}
+
+	from, to := d.RowsInTimeRange(tStart, tEnd)
+	return d.BlockDataByIDRows(id, from, to, 1)
+}
+
+// RowsInTimeRange returns the range of rows [from, to) whose output times t
+// satisfy tStart <= t <= tEnd (up to round-off). The output times are assumed
+// to be sorted.
+func (d *MCellData) RowsInTimeRange(tStart, tEnd float64) (from, to uint64) {
+	times := d.outputTimes()
+	tStart -= 1e-9 * math.Abs(tStart)
+	tEnd += 1e-9 * math.Abs(tEnd)
+	from = uint64(sort.SearchFloat64s(times, tStart))
+	to = uint64(sort.Search(len(times), func(i int) bool { return times[i] > tEnd }))
+	if to < from {
+		to = from
+	}
+	return from, to
+}
+
+// BlockDataByIDRows returns every stride-th row within the range of rows
+// [from, to) of the data block with the given ID as a CountData struct. Only
+// the data covering the requested rows are decoded.
+// NOTE: This is the only method of MCellData which is API sensitive
+func (d *MCellData) BlockDataByIDRows(id, from, to, stride uint64) (*CountData, error) {
+	if id < 0 || id >= d.numBlocks {
+		return nil, d.blockError(ErrOutOfRange, id,
+			"supplied data ID %d is out of range", id)
+	}
+	if from > to || to > d.blockSize || stride == 0 {
+		return nil, d.blockError(ErrOutOfRange, id,
+			"invalid row range [%d, %d) with stride %d for %d rows", from, to, stride,
+			d.blockSize)
 	}
 
 	var c *CountData
 	var e error
-	switch d.API {
+	switch d.api {
 	case API1:
-		c, e = d.blockDataAPI1(id)
-	case API2:
-		c, e = d.blockDataAPI2(id)
+		c, e = d.blockDataAPI1(id, from, to, stride)
+	case API2, ASCII:
+		c, e = d.blockDataAPI2(id, from, to, stride)
 	default:
 		c = nil
-		e = fmt.Errorf("unknown API type %s in BlockDataByID\n", d.API)
+		e = d.blockError(ErrUnknownAPI, id, "unknown API type %s in BlockDataByID", d.api)
+	}
+	if c != nil {
+		c.Meta = d.BlockMetaByID(id)
 	}
 	return c, e
 }
 
-// blockDataAPI1 returns count data for mcell binary API version 2. It returns
-// the data stored in the data block of the given ID as a CountData struct
-func (d *MCellData) blockDataAPI1(id uint64) (*CountData, error) {
+// numStrideRows returns the number of rows within [from, to) for the given
+// stride
+func numStrideRows(from, to, stride uint64) uint64 {
+	return (to - from + stride - 1) / stride
+}
+
+// blockDataAPI1 returns count data for mcell binary API version 1. It returns
+// every stride-th row within [from, to) of the data block of the given ID as
+// a CountData struct
+// NOTE: The API1 type bytes (0 for integer, 1 for double data) are reported as
+// IntType (1) and DoubleType (0). This is a breaking change compared to mbdr
+// 0.7 and earlier which reported the raw type bytes, i.e. their values are
+// swapped. Consumers should use IsInt instead of comparing against 0 or 1.
+func (d *MCellData) blockDataAPI1(id, from, to, stride uint64) (*CountData, error) {
 
-	entry := d.BlockEntries[id]
+	entry := d.blockEntries[id]
 	output := &CountData{}
 	output.Col = make([][]float64, 1)
-	output.Col[0] = make([]float64, 0, d.BlockSize)
-	output.DataTypes = append(output.DataTypes, uint16(entry.Type))
+	output.Col[0] = make([]float64, 0, numStrideRows(from, to, stride))
 
-	loc := entry.Start - d.Offset
-	var buf util.ReadBuf
+	// NOTE: API1 stores integer data as uint32 (type 0) and doubles as type 1.
+	// We translate this into the data types used by API2.
+	var itemLen uint64
 	switch entry.Type {
 	case 0:
-		var val uint32
-		for i := uint64(0); i < d.BlockSize; i++ {
-			buf = (d.Buffer)[loc:]
-			val = buf.Uint32()
-			output.Col[0] = append(output.Col[0], float64(val))
-			loc += util.LenUint32
-		}
-		// sanity check
-		if loc != entry.End-d.Offset {
-			return nil, fmt.Errorf("did not properly reach end of data block %d\n", id)
-		}
-
+		itemLen = util.LenUint32
+		output.DataTypes = append(output.DataTypes, IntType)
 	case 1:
-		var val float64
-		for i := uint64(0); i < d.BlockSize; i++ {
-			buf = (d.Buffer)[loc:]
-			val = buf.Float64()
-			output.Col[0] = append(output.Col[0], val)
-			loc += util.LenFloat64
-		}
-		// sanity check
-		if loc != entry.End-d.Offset {
-			return nil, fmt.Errorf("did not properly reach end of data block %d\n", id)
+		itemLen = util.LenFloat64
+		output.DataTypes = append(output.DataTypes, DoubleType)
+	default:
+		return nil, d.blockError(ErrUnknownDataType, id,
+			"encountered incorrect data type %d", entry.Type)
+	}
+
+	// sanity check
+	if entry.Start < d.offset || entry.End-entry.Start != d.blockSize*itemLen {
+		return nil, d.blockError(ErrCorrupt, id,
+			"did not properly reach end of data block %d", id)
+	}
+
+	buf, err := d.dataAt(id, entry.Start-d.offset+from*itemLen, (to-from)*itemLen)
+	if err != nil {
+		return nil, err
+	}
+
+	for r := from; r < to; r += stride {
+		item := buf[(r-from)*itemLen:]
+		switch entry.Type {
+		case 0:
+			output.Col[0] = append(output.Col[0], float64(item.Uint32()))
+		case 1:
+			output.Col[0] = append(output.Col[0], item.Float64())
 		}
 	}
 	return output, nil
 }
 
 // blockDataAPI2 returns count data for mcell binary API version 2. It returns
-// the data stored in the data block of the given ID as a CountData struct
-func (d *MCellData) blockDataAPI2(id uint64) (*CountData, error) {
-
-	entry := d.BlockInfo[id]
+// every stride-th row within [from, to) of the data block of the given ID as
+// a CountData struct
+// NOTE: The data are stored in stream blocks of OutputBufSize rows each (the
+// last one may be partial). Within each stream block the rows of a given data
+// block are stored contiguously after the rows of all preceding data blocks.
+// This also covers checkpoint files for which the total number of items may
+// be smaller than the output buffer size. Only the stream blocks overlapping
+// the requested rows are accessed.
+func (d *MCellData) blockDataAPI2(id, from, to, stride uint64) (*CountData, error) {
+
+	entry := d.blockInfo[id]
 	output := &CountData{}
 	output.Col = make([][]float64, entry.NumCols)
 	for i := uint64(0); i < entry.NumCols; i++ {
-		output.Col[i] = make([]float64, 0, d.BlockSize)
+		output.Col[i] = make([]float64, 0, numStrideRows(from, to, stride))
 		output.DataTypes = append(output.DataTypes, entry.DataTypes[i])
 	}
 
-	row := uint64(0)
-	stream := uint64(1)
-	var loc uint64
-	// NOTE: We need this to be able to deal with checkpoint files for which
-	// the total number of items may be smaller than the output buffer size
-	if d.BlockSize < d.OutputBufSize {
-		loc = d.BlockSize * util.LenFloat64 * entry.Offset
-	} else {
-		loc = d.OutputBufSize * util.LenFloat64 * entry.Offset
+	if d.outputBufSize == 0 {
+		return nil, d.blockError(ErrCorrupt, id,
+			"encountered invalid output buffer size of 0")
 	}
-	// read all rows until we hit the total blockSize
-	for row < d.BlockSize {
-
-		// forward to the next stream block if we're done parsing the current one
-		if row >= stream*d.OutputBufSize {
-			offset := d.OutputBufSize
-			// last partial block of length <= d.outputBufSize requires special treatment
-			if d.BlockSize-row < d.OutputBufSize {
-				offset = d.BlockSize - row
-			}
-			// forward to beginning of stream block
-			loc = stream * d.OutputBufSize * d.TotalNumCols * util.LenFloat64
 
-			// forward to location within stream block
-			loc += offset * entry.Offset * util.LenFloat64
+	// read all stream blocks overlapping the requested rows
+	rowLen := entry.NumCols * util.LenFloat64
+	for row := from - from%d.outputBufSize; row < to; row += d.outputBufSize {
+		numRows := d.outputBufSize
+		if d.blockSize-row < d.outputBufSize {
+			numRows = d.blockSize - row
+		}
 
-			stream++
+		// first and last requested row within this stream block
+		first, last := row, row+numRows
+		if first < from {
+			first = from
+		}
+		if last > to {
+			last = to
 		}
 
-		// safety check to catch truncated and thus corrupt output files
-		if loc >= uint64(len(d.Buffer)) {
-			return nil, fmt.Errorf("truncated data detected - output file may be corrupt")
+		// forward to beginning of stream block, then to the location of the
+		// data block within the stream block, and finally to the first row
+		loc := row * d.totalNumCols * util.LenFloat64
+		loc += numRows * entry.Offset * util.LenFloat64
+		loc += (first - row) * rowLen
+
+		buf, err := d.dataAt(id, loc, (last-first)*rowLen)
+		if err != nil {
+			return nil, err
 		}
 
-		// read current row
-		for i := uint64(0); i < entry.NumCols; i++ {
-			buf := (d.Buffer)[loc:]
-			val := buf.Float64() //d.buffer[bufLoc:].float64NoSlice()
-			output.Col[i] = append(output.Col[i], val)
-			loc += util.LenFloat64
+		// pick every stride-th row counting from row from
+		r := first
+		if rem := (first - from) % stride; rem != 0 {
+			r += stride - rem
+		}
+		for ; r < last; r += stride {
+			item := buf[(r-first)*rowLen:]
+			for i := uint64(0); i < entry.NumCols; i++ {
+				output.Col[i] = append(output.Col[i], item.Float64())
+				item = item[util.LenFloat64:]
+			}
 		}
-		row++
 	}
 
 	return output, nil
@@ -0,0 +1,187 @@
+package libmbd
+
+import (
+	"encoding/binary"
+	"math"
+	"sort"
+
+	"github.com/haskelladdict/mbdr/parser/util"
+)
+
+// MergeOptions controls how Merge joins checkpoint segments
+type MergeOptions struct {
+	// Starts holds the output time of the first row of each segment and is
+	// only used for segments with STEP output whose output times are relative
+	// to the start of the segment. If nil, the first segment starts at 0 and
+	// each following segment one step after the last output of its
+	// predecessor.
+	Starts []float64
+}
+
+// Merge joins the checkpoint segments of a single simulation seed, provided
+// in chronological order, into one continuous data set. All segments need to
+// contain the same data blocks with matching numbers of columns and data
+// types as well as the same output type (and step size for STEP output).
+// If a simulation was restarted from a checkpoint taken before the end of the
+// previous segment the output times of the two segments overlap. In this case
+// the rows of the earlier segment at or beyond the first output time of the
+// restarted segment are replaced by the latter. A segment starting at the
+// first output time of its predecessors replaces them completely.
+// NOTE: The merged data are held in memory in a single buffer using the
+// layout of NewMCellData. Each data block of each segment is decoded once and
+// only the rows retained in the merged data are decoded. STEP data which
+// remain equidistant and start at 0 are described via STEP, all others via
+// TIME_LIST.
+func Merge(segments []*MCellData, opts MergeOptions) (*MCellData, error) {
+	if len(segments) == 0 {
+		return nil, NewError(ErrEmpty, "no segments to merge")
+	}
+	if opts.Starts != nil && len(opts.Starts) != len(segments) {
+		return nil, NewError(ErrIncompatible, "expected %d segment start times but got %d",
+			len(segments), len(opts.Starts))
+	}
+
+	// the column layout of the merged data is the one of the first segment
+	first := segments[0]
+	layout := make([]DataBlock, first.numBlocks)
+	for id, name := range first.blockNames {
+		countData, err := first.BlockDataByIDRows(uint64(id), 0, 0, 1)
+		if err != nil {
+			return nil, err
+		}
+		layout[id] = DataBlock{name, countData}
+	}
+
+	// determine the output times and the rows of each segment retained in the
+	// merged data
+	var times []float64
+	places := make([]placement, len(segments))
+	for i, s := range segments {
+		if err := compatible(first, s, layout); err != nil {
+			return nil, err
+		}
+		if s.BlockLen() == 0 {
+			continue
+		}
+
+		var segTimes []float64
+		if s.OutputType() == Step {
+			start := 0.0
+			if opts.Starts != nil {
+				start = opts.Starts[i]
+			} else if len(times) > 0 {
+				start = times[len(times)-1] + s.OutputStepLen()
+			}
+			segTimes = make([]float64, s.BlockLen())
+			for r := range segTimes {
+				segTimes[r] = start + float64(r)*s.OutputStepLen()
+			}
+		} else {
+			segTimes = s.outputTimes()
+		}
+		if uint64(len(segTimes)) != s.BlockLen() {
+			return nil, s.newError(ErrCorrupt, "expected %d output times but found %d",
+				s.BlockLen(), len(segTimes))
+		}
+
+		// discard overlapping rows of the preceding segments (up to round-off)
+		tol := 1e-9 * math.Abs(segTimes[0])
+		if len(times) > 0 && times[0] > segTimes[0]+tol {
+			return nil, s.newError(ErrIncompatible, "segment starts at time %g before "+
+				"the preceding segments", segTimes[0])
+		}
+		keep := sort.SearchFloat64s(times, segTimes[0]-tol)
+		times = append(times[:keep], segTimes...)
+		for j := range places[:i] {
+			if places[j].row+places[j].numRows > keep {
+				places[j].numRows = 0
+				if places[j].row < keep {
+					places[j].numRows = keep - places[j].row
+				}
+			}
+		}
+		places[i] = placement{keep, len(segTimes)}
+	}
+
+	// copy the retained rows of all segments into the merged data
+	blockSize := uint64(len(times))
+	var totalNumCols uint64
+	for _, b := range layout {
+		totalNumCols += uint64(len(b.Data.DataTypes))
+	}
+	buf := make([]byte, blockSize*totalNumCols*util.LenFloat64)
+	var offset uint64
+	for _, b := range layout {
+		numCols := uint64(len(b.Data.DataTypes))
+		for i, s := range segments {
+			p := places[i]
+			if p.numRows == 0 {
+				continue
+			}
+			countData, err := s.BlockDataByIDRows(s.blockNameMap[b.Name], 0,
+				uint64(p.numRows), 1)
+			if err != nil {
+				return nil, err
+			}
+
+			loc := (blockSize*offset + uint64(p.row)*numCols) * util.LenFloat64
+			for r := 0; r < p.numRows; r++ {
+				for _, c := range countData.Col {
+					binary.LittleEndian.PutUint64(buf[loc:], math.Float64bits(c[r]))
+					loc += util.LenFloat64
+				}
+			}
+		}
+		offset += numCols
+	}
+
+	spec := NewTimeSpec(times)
+	if first.OutputType() == IterationListType {
+		spec = TimeSpec{OutputListType: IterationListType, TimeList: times}
+	}
+	return FromRaw(inMemory(buf, layout, blockSize, spec)), nil
+}
+
+// placement describes the rows [0, numRows) of a segment which are retained
+// in the merged data starting at row row
+type placement struct {
+	row, numRows int
+}
+
+// compatible checks that the output type and data blocks of segment s match
+// those of segment first whose column layout is provided
+func compatible(first, s *MCellData, layout []DataBlock) error {
+	if s.OutputType() != first.OutputType() {
+		return s.newError(ErrIncompatible, "output type differs from that of %s",
+			first.fileName)
+	}
+	if s.OutputType() == Step && s.OutputStepLen() != first.OutputStepLen() {
+		return s.newError(ErrIncompatible, "step size %g differs from step size %g "+
+			"of %s", s.OutputStepLen(), first.OutputStepLen(), first.fileName)
+	}
+	if s.NumDataBlocks() != first.NumDataBlocks() {
+		return s.newError(ErrIncompatible, "found %d data blocks but %s has %d",
+			s.NumDataBlocks(), first.fileName, first.NumDataBlocks())
+	}
+	for _, b := range layout {
+		id, ok := s.blockNameMap[b.Name]
+		if !ok {
+			return s.newError(ErrIncompatible, "data block %s is missing", b.Name)
+		}
+		countData, err := s.BlockDataByIDRows(id, 0, 0, 1)
+		if err != nil {
+			return err
+		}
+		if len(countData.DataTypes) != len(b.Data.DataTypes) {
+			return s.newError(ErrIncompatible, "data block %s has %d instead of %d "+
+				"columns", b.Name, len(countData.DataTypes), len(b.Data.DataTypes))
+		}
+		for c, t := range countData.DataTypes {
+			if t != b.Data.DataTypes[c] {
+				return s.newError(ErrIncompatible, "data type of column %d of data "+
+					"block %s differs", c, b.Name)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package libmbd
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// TestMerge checks the output times and values of merged segments including
+// overlapping and restarted segments
+func TestMerge(t *testing.T) {
+	newData := func(spec TimeSpec, col ...float64) *MCellData {
+		d, err := NewMCellData([]DataBlock{{"a", &CountData{Col: [][]float64{col},
+			DataTypes: []uint16{IntType}}}}, spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return d
+	}
+	timeList := func(times ...float64) TimeSpec {
+		return TimeSpec{OutputListType: TimeListType, TimeList: times}
+	}
+	step := TimeSpec{OutputListType: Step, StepSize: 1}
+
+	tests := []struct {
+		name      string
+		segments  []*MCellData
+		starts    []float64
+		wantTimes []float64
+		wantCol   []float64
+	}{
+		{"consecutive", []*MCellData{newData(timeList(0, 1), 1, 2),
+			newData(timeList(2, 3), 3, 4)}, nil, []float64{0, 1, 2, 3},
+			[]float64{1, 2, 3, 4}},
+		{"overlap", []*MCellData{newData(timeList(0, 1, 2), 1, 2, 3),
+			newData(timeList(1, 2, 3), 5, 6, 7)}, nil, []float64{0, 1, 2, 3},
+			[]float64{1, 5, 6, 7}},
+		{"exact restart", []*MCellData{newData(timeList(0.1, 0.2), 1, 2),
+			newData(timeList(0.1, 0.2, 0.3), 5, 6, 7)}, nil, []float64{0.1, 0.2, 0.3},
+			[]float64{5, 6, 7}},
+		{"STEP", []*MCellData{newData(step, 1, 2), newData(step, 3, 4)}, nil,
+			[]float64{0, 1, 2, 3}, []float64{1, 2, 3, 4}},
+		{"STEP starts", []*MCellData{newData(step, 1, 2, 3), newData(step, 5, 6)},
+			[]float64{0, 2}, []float64{0, 1, 2, 3}, []float64{1, 2, 5, 6}},
+		{"STEP restart", []*MCellData{newData(step, 1, 2, 3), newData(step, 5, 6)},
+			[]float64{0, 0}, []float64{0, 1}, []float64{5, 6}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			merged, err := Merge(tt.segments, MergeOptions{Starts: tt.starts})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if times := merged.OutputTimes(); !reflect.DeepEqual(times, tt.wantTimes) {
+				t.Errorf("got output times %v, want %v", times, tt.wantTimes)
+			}
+			c, err := merged.BlockDataByName("a")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(c.Col[0], tt.wantCol) {
+				t.Errorf("got values %v, want %v", c.Col[0], tt.wantCol)
+			}
+		})
+	}
+}
+
+// TestMergeErrors checks that incompatible segments are rejected
+func TestMergeErrors(t *testing.T) {
+	newData := func(spec TimeSpec, name string, col ...float64) *MCellData {
+		d, err := NewMCellData([]DataBlock{{name, &CountData{Col: [][]float64{col},
+			DataTypes: []uint16{IntType}}}}, spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return d
+	}
+	timeList := func(times ...float64) TimeSpec {
+		return TimeSpec{OutputListType: TimeListType, TimeList: times}
+	}
+	step := TimeSpec{OutputListType: Step, StepSize: 1}
+
+	tests := []struct {
+		name     string
+		segments []*MCellData
+		starts   []float64
+		kind     error
+	}{
+		{"none", nil, nil, ErrEmpty},
+		{"starts before", []*MCellData{newData(timeList(1, 2), "a", 1, 2),
+			newData(timeList(0, 1), "a", 3, 4)}, nil, ErrIncompatible},
+		{"STEP starts before", []*MCellData{newData(step, "a", 1, 2),
+			newData(step, "a", 3, 4)}, []float64{1, 0}, ErrIncompatible},
+		{"number of starts", []*MCellData{newData(step, "a", 1, 2),
+			newData(step, "a", 3, 4)}, []float64{0}, ErrIncompatible},
+		{"data blocks", []*MCellData{newData(step, "a", 1, 2),
+			newData(step, "b", 3, 4)}, nil, ErrIncompatible},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Merge(tt.segments, MergeOptions{Starts: tt.starts})
+			if !errors.Is(err, tt.kind) {
+				t.Errorf("got error %v, want %v", err, tt.kind)
+			}
+		})
+	}
+}
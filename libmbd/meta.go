@@ -0,0 +1,151 @@
+package libmbd
+
+import (
+	"regexp"
+	"sort"
+)
+
+// BlockMeta holds user supplied metadata of a data block such as the labels
+// of its columns (e.g. the MCell count expressions), their units, and any
+// free-form information.
+type BlockMeta struct {
+	Labels []string          `json:"labels,omitempty"`
+	Units  []string          `json:"units,omitempty"`
+	Info   map[string]string `json:"info,omitempty"`
+}
+
+// Empty tests if the metadata contain no information
+func (m BlockMeta) Empty() bool {
+	return len(m.Labels) == 0 && len(m.Units) == 0 && len(m.Info) == 0
+}
+
+// Label returns the label of column i or "" if there is none
+func (m BlockMeta) Label(i int) string {
+	if i < 0 || i >= len(m.Labels) {
+		return ""
+	}
+	return m.Labels[i]
+}
+
+// Unit returns the unit of column i or "" if there is none
+func (m BlockMeta) Unit(i int) string {
+	if i < 0 || i >= len(m.Units) {
+		return ""
+	}
+	return m.Units[i]
+}
+
+// clone returns a deep copy of the metadata
+func (m BlockMeta) clone() BlockMeta {
+	c := BlockMeta{}
+	if m.Labels != nil {
+		c.Labels = append([]string{}, m.Labels...)
+	}
+	if m.Units != nil {
+		c.Units = append([]string{}, m.Units...)
+	}
+	if m.Info != nil {
+		c.Info = make(map[string]string, len(m.Info))
+		for k, v := range m.Info {
+			c.Info[k] = v
+		}
+	}
+	return c
+}
+
+// BlockMetaByID returns a copy of the metadata of the data block with the
+// given ID
+func (d *MCellData) BlockMetaByID(id uint64) BlockMeta {
+	d.metaMu.RLock()
+	defer d.metaMu.RUnlock()
+
+	if id >= uint64(len(d.meta)) {
+		return BlockMeta{}
+	}
+	return d.meta[id].clone()
+}
+
+// SetMeta attaches metadata to the data blocks. The keys of meta are either
+// data block names or regular expressions matching the complete name of data
+// blocks (e.g. to cover the data blocks of all seeds). Exact names take
+// precedence over regular expressions. If several regular expressions match
+// a data block the longest one is used as the most specific, ties are broken
+// by lexical order.
+// Keys which are neither the name of a data block nor a valid regular
+// expression are reported as error. The number of labels and units has to
+// match the number of columns of the data block if known. In case of an error
+// the metadata of d remain unchanged.
+// NOTE: The metadata are copied and replaced atomically, i.e. concurrent
+// accessors see either the previous or the new metadata.
+func (d *MCellData) SetMeta(meta map[string]BlockMeta) error {
+	var keys []string
+	for k := range meta {
+		if _, ok := d.blockNameMap[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if len(keys[i]) != len(keys[j]) {
+			return len(keys[i]) > len(keys[j])
+		}
+		return keys[i] < keys[j]
+	})
+
+	patterns := make([]*regexp.Regexp, len(keys))
+	for i, k := range keys {
+		regex, err := regexp.Compile("^(?:" + k + ")$")
+		if err != nil {
+			e := d.newError(ErrCorrupt, "metadata key %s is neither a data block name "+
+				"nor a valid regular expression", k)
+			e.Err = err
+			return e
+		}
+		patterns[i] = regex
+	}
+
+	// metadata are only replaced once all data blocks were checked
+	d.metaMu.Lock()
+	defer d.metaMu.Unlock()
+	var assigned []BlockMeta
+	for id, n := range d.blockNames {
+		m, ok := meta[n]
+		for i := 0; !ok && i < len(patterns); i++ {
+			if patterns[i].MatchString(n) {
+				m, ok = meta[keys[i]]
+			}
+		}
+		if !ok {
+			continue
+		}
+
+		if numCols, ok := d.numCols(uint64(id)); ok &&
+			((m.Labels != nil && uint64(len(m.Labels)) != numCols) ||
+				(m.Units != nil && uint64(len(m.Units)) != numCols)) {
+			return d.blockError(ErrIncompatible, uint64(id), "metadata of data block %s "+
+				"do not match its %d column(s)", n, numCols)
+		}
+		if assigned == nil {
+			assigned = make([]BlockMeta, d.numBlocks)
+			copy(assigned, d.meta)
+		}
+		assigned[id] = m.clone()
+	}
+	if assigned != nil {
+		d.meta = assigned
+	}
+	return nil
+}
+
+// numCols returns the number of columns of the data block with the given ID
+// if it is known without decoding the data block
+func (d *MCellData) numCols(id uint64) (uint64, bool) {
+	switch d.api {
+	case API1:
+		return 1, true
+	case API2, ASCII:
+		if id < uint64(len(d.blockInfo)) {
+			return d.blockInfo[id].NumCols, true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,58 @@
+package libmbd
+
+import (
+	"errors"
+	"testing"
+)
+
+// testData returns in-memory data with single column data blocks of the
+// given names
+func testData(t *testing.T, names ...string) *MCellData {
+	var blocks []DataBlock
+	for _, n := range names {
+		blocks = append(blocks, DataBlock{n, &CountData{Col: [][]float64{{1, 2}},
+			DataTypes: []uint16{IntType}}})
+	}
+	d, err := NewMCellData(blocks, TimeSpec{OutputListType: Step, StepSize: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+func TestSetMetaPrecedence(t *testing.T) {
+	meta := map[string]BlockMeta{
+		"ca.1":      {Labels: []string{"exact"}},
+		`ca\..*`:    {Labels: []string{"ca"}},
+		`.*`:        {Labels: []string{"any"}},
+		`ca\.[0-9]`: {Labels: []string{"digit"}},
+	}
+	want := map[string]string{"ca.1": "exact", "ca.2": "digit", "ca.x": "ca",
+		"b.1": "any"}
+
+	// repeat to catch a dependence on the iteration order of meta
+	for i := 0; i < 20; i++ {
+		d := testData(t, "ca.1", "ca.2", "ca.x", "b.1")
+		if err := d.SetMeta(meta); err != nil {
+			t.Fatal(err)
+		}
+		for n, label := range want {
+			id, _ := d.BlockNameToID(n)
+			if got := d.BlockMetaByID(id).Label(0); got != label {
+				t.Fatalf("%s: got label %q, want %q", n, got, label)
+			}
+		}
+	}
+}
+
+func TestSetMetaInvalidKey(t *testing.T) {
+	d := testData(t, "ca(1")
+	// invalid regular expressions are accepted as names of data blocks only
+	if err := d.SetMeta(map[string]BlockMeta{"ca(1": {}}); err != nil {
+		t.Fatal(err)
+	}
+	err := d.SetMeta(map[string]BlockMeta{"b(1": {}})
+	if !errors.Is(err, ErrCorrupt) {
+		t.Errorf("got error %v, want %v", err, ErrCorrupt)
+	}
+}
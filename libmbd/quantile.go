@@ -0,0 +1,119 @@
+package libmbd
+
+import (
+	"math"
+	"sort"
+)
+
+// p2Sketch estimates a single quantile of a stream of values in constant
+// memory via the P² algorithm (R. Jain and I. Chlamtac, Commun. ACM 28, 1076
+// (1985)). The sketch tracks five markers whose heights approximate the
+// minimum, the p/2, p, and (1+p)/2 quantiles, and the maximum. Until five
+// values have been seen the values themselves are stored and the quantile
+// is computed exactly.
+// NOTE: The number of values seen so far and the marker increments dn are
+// shared by all sketches of an Ensemble and are hence passed in by the
+// caller instead of being stored with each sketch. Likewise, the markers of
+// all sketches of a data block are kept in two shared slices and a sketch
+// merely views its five entries of each (see newP2Sketch).
+type p2Sketch struct {
+	q   []float64 // marker heights
+	pos []int32   // marker positions (1 based)
+}
+
+// newP2Sketch returns the i-th sketch whose markers are stored in heights
+// and positions
+func newP2Sketch(heights []float64, positions []int32, i int) p2Sketch {
+	return p2Sketch{q: heights[5*i : 5*i+5], pos: positions[5*i : 5*i+5]}
+}
+
+// p2Increments returns the increments of the desired marker positions per
+// added value for quantile p
+func p2Increments(p float64) [5]float64 {
+	return [5]float64{0, p / 2, p, (1 + p) / 2, 1}
+}
+
+// add adds x as the n-th value (1 based) to the sketch
+func (s *p2Sketch) add(x float64, n uint64, dn *[5]float64) {
+	if n <= 5 {
+		s.q[n-1] = x
+		if n == 5 {
+			sort.Float64s(s.q)
+			for i := range s.pos {
+				s.pos[i] = int32(i + 1)
+			}
+		}
+		return
+	}
+
+	// determine the cell containing x and update the extreme markers
+	var k int
+	switch {
+	case x < s.q[0]:
+		s.q[0] = x
+		k = 0
+	case x >= s.q[4]:
+		s.q[4] = x
+		k = 3
+	default:
+		for k = 0; k < 3 && x >= s.q[k+1]; k++ {
+		}
+	}
+	for i := k + 1; i < 5; i++ {
+		s.pos[i]++
+	}
+
+	// adjust the heights of the three middle markers if they are off their
+	// desired positions by more than one
+	for i := 1; i < 4; i++ {
+		d := 1 + float64(n-1)*dn[i] - float64(s.pos[i])
+		if (d >= 1 && s.pos[i+1]-s.pos[i] > 1) || (d <= -1 && s.pos[i-1]-s.pos[i] < -1) {
+			step := int32(1)
+			if d < 0 {
+				step = -1
+			}
+			q := s.parabolic(i, step)
+			if q <= s.q[i-1] || q >= s.q[i+1] {
+				q = s.linear(i, step)
+			}
+			s.q[i] = q
+			s.pos[i] += step
+		}
+	}
+}
+
+// parabolic returns the piecewise parabolic prediction of the height of
+// marker i after moving it by step
+func (s *p2Sketch) parabolic(i int, step int32) float64 {
+	d := float64(step)
+	n0, n1, n2 := float64(s.pos[i-1]), float64(s.pos[i]), float64(s.pos[i+1])
+	return s.q[i] + d/(n2-n0)*((n1-n0+d)*(s.q[i+1]-s.q[i])/(n2-n1)+
+		(n2-n1-d)*(s.q[i]-s.q[i-1])/(n1-n0))
+}
+
+// linear returns the linear prediction of the height of marker i after
+// moving it by step
+func (s *p2Sketch) linear(i int, step int32) float64 {
+	j := i + int(step)
+	return s.q[i] + float64(step)*(s.q[j]-s.q[i])/float64(s.pos[j]-s.pos[i])
+}
+
+// value returns the estimate of quantile p after n values have been added
+func (s *p2Sketch) value(p float64, n uint64) float64 {
+	switch {
+	case n == 0:
+		return math.NaN()
+	case n > 5:
+		return s.q[2]
+	}
+
+	// up to five values are kept verbatim and interpolated linearly
+	vals := append([]float64(nil), s.q[:n]...)
+	sort.Float64s(vals)
+	x := p * float64(n-1)
+	i := int(x)
+	if i+1 >= len(vals) {
+		return vals[len(vals)-1]
+	}
+	return vals[i] + (x-float64(i))*(vals[i+1]-vals[i])
+}
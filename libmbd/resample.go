@@ -0,0 +1,249 @@
+package libmbd
+
+import (
+	"math"
+	"sort"
+)
+
+// ResampleMethod determines how Resample computes the values at the points of
+// the new time grid
+type ResampleMethod int
+
+// list of available resampling methods
+const (
+	// ZeroOrderHold uses the value of the last output time at or before each
+	// grid point. This is the right choice for molecule counts which change
+	// in discrete steps and retains the data types of the columns.
+	ZeroOrderHold ResampleMethod = iota
+	// Linear interpolates linearly between the two output times enclosing
+	// each grid point.
+	Linear
+	// BinAverage averages all values whose output times fall into the bin
+	// around each grid point. The bins extend half way to the neighboring
+	// grid points. Empty bins are interpolated linearly.
+	BinAverage
+)
+
+// resampleMethodNames maps the resampling methods to their names
+var resampleMethodNames = map[ResampleMethod]string{
+	ZeroOrderHold: "hold",
+	Linear:        "linear",
+	BinAverage:    "average",
+}
+
+// String returns the name of the resampling method
+func (m ResampleMethod) String() string {
+	if n, ok := resampleMethodNames[m]; ok {
+		return n
+	}
+	return "unknown"
+}
+
+// ParseResampleMethod returns the resampling method of the given name (hold,
+// linear, or average)
+func ParseResampleMethod(name string) (ResampleMethod, error) {
+	for m, n := range resampleMethodNames {
+		if n == name {
+			return m, nil
+		}
+	}
+	return 0, NewError(ErrInvalidArgument, "unknown resampling method %s", name)
+}
+
+// maxGridSize is the maximum number of points of a uniform time grid. Larger
+// grids are almost certainly due to a mistyped spacing and would exhaust
+// memory once resampled data are allocated.
+const maxGridSize = 1 << 24
+
+// UniformGrid returns the equidistant time grid tStart, tStart+dt, ... up to
+// and including tEnd (up to round-off). Grids of more than 2^24 points are
+// rejected.
+func UniformGrid(tStart, tEnd, dt float64) ([]float64, error) {
+	if !(dt > 0) {
+		return nil, NewError(ErrInvalidArgument, "time grid spacing %g is not positive", dt)
+	}
+	if tEnd < tStart {
+		return nil, NewError(ErrInvalidArgument, "time grid end %g is before its start %g",
+			tEnd, tStart)
+	}
+
+	// compare in floating point to catch overflows and NaNs
+	steps := math.Floor((tEnd-tStart)/dt + 1e-9)
+	if !(steps < maxGridSize) {
+		return nil, NewError(ErrInvalidArgument, "time grid [%g, %g] with spacing %g "+
+			"exceeds %d points", tStart, tEnd, dt, maxGridSize)
+	}
+	n := int(steps) + 1
+	grid := make([]float64, n)
+	for i := range grid {
+		grid[i] = tStart + float64(i)*dt
+	}
+	return grid, nil
+}
+
+// Resample maps count data with the given output times onto the provided time
+// grid using the requested method. Both the output times and the grid have to
+// be sorted and the grid has to lie within the range of the output times (up
+// to round-off). Apart from ZeroOrderHold, the resampled columns hold double
+// data.
+func Resample(c *CountData, times, grid []float64, method ResampleMethod) (*CountData,
+	error) {
+
+	for i, col := range c.Col {
+		if len(col) != len(times) {
+			return nil, NewError(ErrIncompatible, "column %d has %d rows but there are %d "+
+				"output times", i, len(col), len(times))
+		}
+	}
+	if len(grid) != 0 && len(times) == 0 {
+		return nil, NewError(ErrInvalidArgument, "can not resample data without output times")
+	}
+	for i, t := range grid {
+		if i > 0 && t < grid[i-1] {
+			return nil, NewError(ErrInvalidArgument, "time grid is not sorted")
+		}
+		if t < times[0]-roundOff(times[0]) || t > times[len(times)-1]+
+			roundOff(times[len(times)-1]) {
+			return nil, NewError(ErrInvalidArgument, "time grid point %g is outside of the "+
+				"output times [%g, %g]", t, times[0], times[len(times)-1])
+		}
+	}
+
+	output := &CountData{
+		Col:       make([][]float64, len(c.Col)),
+		DataTypes: make([]uint16, len(c.Col)),
+		Meta:      c.Meta,
+	}
+	for i := range output.Col {
+		output.Col[i] = make([]float64, len(grid))
+		output.DataTypes[i] = DoubleType
+	}
+
+	switch method {
+	case ZeroOrderHold:
+		copy(output.DataTypes, c.DataTypes)
+		for g, t := range grid {
+			r := holdIndex(times, t)
+			for i, col := range c.Col {
+				output.Col[i][g] = col[r]
+			}
+		}
+
+	case Linear:
+		for g, t := range grid {
+			interpolate(output, c, times, g, t)
+		}
+
+	case BinAverage:
+		for g, t := range grid {
+			lo, hi := math.Inf(-1), math.Inf(1)
+			if g > 0 {
+				lo = (grid[g-1] + t) / 2
+			} else if len(grid) > 1 {
+				lo = t - (grid[1]-t)/2
+			}
+			if g < len(grid)-1 {
+				hi = (t + grid[g+1]) / 2
+			} else if len(grid) > 1 {
+				hi = t + (t-grid[g-1])/2
+			}
+
+			first := sort.SearchFloat64s(times, lo)
+			last := sort.SearchFloat64s(times, hi)
+			if first >= last {
+				interpolate(output, c, times, g, t)
+				continue
+			}
+			for i, col := range c.Col {
+				var sum float64
+				for r := first; r < last; r++ {
+					sum += col[r]
+				}
+				output.Col[i][g] = sum / float64(last-first)
+			}
+		}
+
+	default:
+		return nil, NewError(ErrInvalidArgument, "unknown resampling method %d", method)
+	}
+	return output, nil
+}
+
+// Resample returns the data blocks with the given IDs (or all data blocks if
+// none are provided) resampled onto the provided time grid as in-memory
+// MCellData (see NewMCellData and Resample)
+func (d *MCellData) Resample(grid []float64, method ResampleMethod,
+	ids ...uint64) (*MCellData, error) {
+
+	if len(ids) == 0 {
+		for id := uint64(0); id < d.numBlocks; id++ {
+			ids = append(ids, id)
+		}
+	}
+
+	times := d.outputTimes()
+	blocks := make([]DataBlock, 0, len(ids))
+	for _, id := range ids {
+		name, err := d.IDtoBlockName(id)
+		if err != nil {
+			return nil, err
+		}
+		countData, err := d.BlockDataByID(id)
+		if err != nil {
+			return nil, err
+		}
+		resampled, err := Resample(countData, times, grid, method)
+		if err != nil {
+			return nil, WithFile(err, d.fileName)
+		}
+		blocks = append(blocks, DataBlock{name, resampled})
+	}
+
+	raw, err := NewRawData(blocks, gridTimeSpec(grid, d.OutputType()))
+	if err != nil {
+		return nil, err
+	}
+	raw.FileName = d.fileName
+	return FromRaw(raw), nil
+}
+
+// gridTimeSpec returns the time specification of a time grid replacing output
+// times of the given output type. Grids of iterations remain iteration lists.
+func gridTimeSpec(grid []float64, outputType uint16) TimeSpec {
+	if outputType == IterationListType {
+		return TimeSpec{OutputListType: IterationListType, TimeList: grid}
+	}
+	return NewTimeSpec(grid)
+}
+
+// holdIndex returns the index of the last output time at or before t (up to
+// round-off). Times before the first output time map to the first row.
+func holdIndex(times []float64, t float64) int {
+	r := sort.Search(len(times), func(i int) bool { return times[i] > t+roundOff(t) })
+	if r > 0 {
+		r--
+	}
+	return r
+}
+
+// interpolate sets row g of all columns of output to the values of c
+// interpolated linearly to time t
+func interpolate(output, c *CountData, times []float64, g int, t float64) {
+	r := holdIndex(times, t)
+	var w float64
+	if r+1 < len(times) && times[r+1] > times[r] {
+		w = math.Max(0, math.Min(1, (t-times[r])/(times[r+1]-times[r])))
+	}
+	for i, col := range c.Col {
+		if w == 0 {
+			output.Col[i][g] = col[r]
+			continue
+		}
+		output.Col[i][g] = (1-w)*col[r] + w*col[r+1]
+	}
+}
+
+// roundOff returns the tolerance used when comparing output time t
+func roundOff(t float64) float64 {
+	return 1e-9 * math.Abs(t)
+}
@@ -0,0 +1,156 @@
+package libmbd
+
+import (
+	"errors"
+	"math"
+	"reflect"
+	"testing"
+)
+
+// TestUniformGrid checks the construction of time grids including the
+// rejection of invalid and oversized grids
+func TestUniformGrid(t *testing.T) {
+	tests := []struct {
+		name             string
+		tStart, tEnd, dt float64
+		want             []float64
+	}{
+		{"exact", 0, 1, 0.25, []float64{0, 0.25, 0.5, 0.75, 1}},
+		{"round-off", 0, 0.3, 0.1, []float64{0, 0.1, 0.2, 0.30000000000000004}},
+		{"partial step", 1, 2.5, 1, []float64{1, 2}},
+		{"single point", 2, 2, 1, []float64{2}},
+		{"zero spacing", 0, 1, 0, nil},
+		{"negative spacing", 0, 1, -0.1, nil},
+		{"reversed", 1, 0, 0.1, nil},
+		{"oversized", 0, 1, 1e-9, nil},
+		{"overflow", 0, 1e300, 1e-300, nil},
+		{"NaN", math.NaN(), 1, 0.1, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			grid, err := UniformGrid(tt.tStart, tt.tEnd, tt.dt)
+			if tt.want == nil {
+				if !errors.Is(err, ErrInvalidArgument) {
+					t.Errorf("got grid of %d points (%v), want %v", len(grid), err,
+						ErrInvalidArgument)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(grid, tt.want) {
+				t.Errorf("got grid %v, want %v", grid, tt.want)
+			}
+		})
+	}
+}
+
+// TestResample checks the values of all resampling methods on a non-uniform
+// set of output times
+func TestResample(t *testing.T) {
+	times := []float64{0, 1, 2, 4}
+	c := &CountData{Col: [][]float64{{0, 10, 30, 50}, {1, 1, 1, 1}},
+		DataTypes: []uint16{IntType, DoubleType}}
+
+	tests := []struct {
+		name   string
+		method ResampleMethod
+		grid   []float64
+		want   []float64
+	}{
+		{"hold", ZeroOrderHold, []float64{0, 0.5, 1.5, 3, 4}, []float64{0, 0, 10, 30, 50}},
+		{"hold round-off", ZeroOrderHold, []float64{1 - 1e-12, 4 + 1e-12},
+			[]float64{10, 50}},
+		{"linear", Linear, []float64{0, 0.5, 1.5, 3, 4}, []float64{0, 5, 20, 40, 50}},
+		{"average", BinAverage, []float64{0, 2, 4}, []float64{0, 20, 50}},
+		{"average empty bin", BinAverage, []float64{0, 1, 2, 3, 4},
+			[]float64{0, 10, 30, 40, 50}},
+		{"average single bin", BinAverage, []float64{2}, []float64{22.5}},
+		{"empty grid", Linear, []float64{}, []float64{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := Resample(c, times, tt.grid, tt.method)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(r.Col[0], tt.want) {
+				t.Errorf("got %v, want %v", r.Col[0], tt.want)
+			}
+			for _, v := range r.Col[1] {
+				if v != 1 {
+					t.Errorf("got %v for constant column", r.Col[1])
+					break
+				}
+			}
+			types := []uint16{DoubleType, DoubleType}
+			if tt.method == ZeroOrderHold {
+				types = c.DataTypes
+			}
+			if !reflect.DeepEqual(r.DataTypes, types) {
+				t.Errorf("got data types %v, want %v", r.DataTypes, types)
+			}
+		})
+	}
+}
+
+// TestResampleErrors checks the rejection of invalid grids, methods, and
+// output times
+func TestResampleErrors(t *testing.T) {
+	times := []float64{0, 1, 2}
+	c := &CountData{Col: [][]float64{{1, 2, 3}}, DataTypes: []uint16{IntType}}
+
+	tests := []struct {
+		name   string
+		times  []float64
+		grid   []float64
+		method ResampleMethod
+		kind   error
+	}{
+		{"before", times, []float64{-0.5, 1}, Linear, ErrInvalidArgument},
+		{"after", times, []float64{1, 2.5}, ZeroOrderHold, ErrInvalidArgument},
+		{"unsorted", times, []float64{1, 0.5}, BinAverage, ErrInvalidArgument},
+		{"unknown method", times, []float64{1}, ResampleMethod(7), ErrInvalidArgument},
+		{"no output times", nil, []float64{1}, Linear, ErrIncompatible},
+		{"row mismatch", times[:2], []float64{1}, Linear, ErrIncompatible},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Resample(c, tt.times, tt.grid, tt.method); !errors.Is(err, tt.kind) {
+				t.Errorf("got error %v, want %v", err, tt.kind)
+			}
+		})
+	}
+}
+
+// TestMCellDataResample checks that resampled MCellData carry the grid as
+// their output times
+func TestMCellDataResample(t *testing.T) {
+	d, err := NewMCellData([]DataBlock{
+		{"a", &CountData{Col: [][]float64{{0, 10, 20, 30}}, DataTypes: []uint16{IntType}}},
+		{"b", &CountData{Col: [][]float64{{3, 2, 1, 0}}, DataTypes: []uint16{IntType}}},
+	}, TimeSpec{OutputListType: Step, StepSize: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	grid, err := UniformGrid(0.5, 2.5, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := d.Resample(grid, Linear, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(r.DataNames(), []string{"b"}) ||
+		!reflect.DeepEqual(r.OutputTimes(), grid) {
+		t.Fatalf("got data blocks %v with output times %v", r.DataNames(), r.OutputTimes())
+	}
+	c, err := r.BlockDataByName("b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []float64{2.5, 1.5, 0.5}; !reflect.DeepEqual(c.Col[0], want) {
+		t.Errorf("got %v, want %v", c.Col[0], want)
+	}
+}
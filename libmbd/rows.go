@@ -0,0 +1,180 @@
+package libmbd
+
+import (
+	"strconv"
+)
+
+// ColumnInfo describes a single column of the rows returned by a RowIterator
+type ColumnInfo struct {
+	Block    string // name of the data block
+	Col      int    // index of the column within the data block
+	DataType uint16 // data type of the column (IntType or DoubleType)
+	Label    string // label of the column (if any)
+	Unit     string // unit of the column (if any)
+}
+
+// RowIterator iterates over the rows of a set of data blocks, i.e. over the
+// output times and the values of all columns of the data blocks at each of
+// them. Only the rows of a single stream block (OutputBufSize rows for API2
+// data) are decoded at a time which keeps memory use proportional to the
+// number of columns even for lazily read data.
+//
+//	it, err := data.Rows(ids...)
+//	for it.Next() {
+//		t, values := it.Time(), it.Values()
+//		...
+//	}
+//	if err := it.Err(); err != nil {
+//		...
+//	}
+//
+// Several RowIterators may share the same MCellData but a single RowIterator
+// must not be used by multiple goroutines.
+type RowIterator struct {
+	d                *MCellData
+	ids              []uint64
+	times            []float64
+	from, to, stride uint64
+	cols             []ColumnInfo
+
+	chunk      []*CountData // decoded rows of the current stream block
+	chunkStart uint64       // first row of the current stream block
+	chunkLen   uint64       // number of decoded rows of the current stream block
+	pos        uint64       // index of the current row within chunk
+	started    bool
+	done       bool
+
+	values []float64
+	err    error
+}
+
+// Rows returns a RowIterator over all rows of the data blocks with the given
+// IDs
+func (d *MCellData) Rows(ids ...uint64) (*RowIterator, error) {
+	return d.RowsInRange(ids, 0, d.blockSize, 1)
+}
+
+// RowsInRange returns a RowIterator over every stride-th row within [from, to)
+// of the data blocks with the given IDs
+func (d *MCellData) RowsInRange(ids []uint64, from, to, stride uint64) (*RowIterator,
+	error) {
+	if from > to || to > d.blockSize || stride == 0 {
+		return nil, d.newError(ErrOutOfRange, "invalid row range [%d, %d) with stride %d "+
+			"for %d rows", from, to, stride, d.blockSize)
+	}
+
+	it := &RowIterator{d: d, ids: ids, times: d.outputTimes(), from: from, to: to,
+		stride: stride}
+	if uint64(len(it.times)) < to {
+		return nil, d.newError(ErrCorrupt, "expected %d output times but found %d",
+			d.blockSize, len(it.times))
+	}
+
+	// determine the columns by decoding an empty range of rows of each data block
+	for _, id := range ids {
+		countData, err := d.BlockDataByIDRows(id, from, from, 1)
+		if err != nil {
+			return nil, err
+		}
+		for c, t := range countData.DataTypes {
+			it.cols = append(it.cols, ColumnInfo{
+				Block:    d.blockNames[id],
+				Col:      c,
+				DataType: t,
+				Label:    countData.Meta.Label(c),
+				Unit:     countData.Meta.Unit(c),
+			})
+		}
+	}
+	it.values = make([]float64, len(it.cols))
+	return it, nil
+}
+
+// Columns describes the columns of the values returned by Values
+func (it *RowIterator) Columns() []ColumnInfo {
+	return it.cols
+}
+
+// Next advances the iterator to the next row. It returns false once all rows
+// have been visited or an error occurred.
+func (it *RowIterator) Next() bool {
+	if it.done {
+		return false
+	}
+
+	if !it.started {
+		it.started = true
+		it.chunkStart = it.from
+		it.done = it.from >= it.to || !it.nextChunk()
+	} else if it.pos++; it.pos >= it.chunkLen {
+		it.chunkStart += it.chunkLen * it.stride
+		it.done = it.chunkStart >= it.to || !it.nextChunk()
+	}
+	if it.done {
+		return false
+	}
+
+	i := 0
+	for _, c := range it.chunk {
+		for _, col := range c.Col {
+			it.values[i] = col[it.pos]
+			i++
+		}
+	}
+	return true
+}
+
+// nextChunk decodes the rows of the stream block containing chunkStart
+func (it *RowIterator) nextChunk() bool {
+	chunkSize := it.d.outputBufSize
+	if chunkSize == 0 {
+		chunkSize = DefaultOutputBufSize
+	}
+	end := (it.chunkStart/chunkSize + 1) * chunkSize
+	if end > it.to {
+		end = it.to
+	}
+
+	it.chunk = it.chunk[:0]
+	for _, id := range it.ids {
+		countData, err := it.d.BlockDataByIDRows(id, it.chunkStart, end, it.stride)
+		if err != nil {
+			it.err = err
+			return false
+		}
+		it.chunk = append(it.chunk, countData)
+	}
+	it.chunkLen = numStrideRows(it.chunkStart, end, it.stride)
+	it.pos = 0
+	return true
+}
+
+// Row returns the index of the current row
+func (it *RowIterator) Row() uint64 {
+	return it.chunkStart + it.pos*it.stride
+}
+
+// Time returns the output time of the current row
+func (it *RowIterator) Time() float64 {
+	return it.times[it.Row()]
+}
+
+// Values returns the values of all columns in the current row. The returned
+// slice is reused by subsequent calls of Next.
+func (it *RowIterator) Values() []float64 {
+	return it.values
+}
+
+// FormatValue returns the string representation of the value of column i in
+// the current row according to the column's data type
+func (it *RowIterator) FormatValue(i int) string {
+	if it.cols[i].DataType == IntType {
+		return strconv.FormatInt(int64(it.values[i]), 10)
+	}
+	return strconv.FormatFloat(it.values[i], 'g', -1, 64)
+}
+
+// Err returns the error (if any) encountered during iteration
+func (it *RowIterator) Err() error {
+	return it.err
+}
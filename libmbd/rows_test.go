@@ -0,0 +1,115 @@
+package libmbd
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/haskelladdict/mbdr/parser/util"
+)
+
+// rowsFixture returns API1 and API2 data with 10 rows of STEP output every
+// 0.1 s whose values are the row numbers
+func rowsFixture(t *testing.T) map[string]*MCellData {
+	col := make([]float64, 10)
+	buf := make(util.ReadBuf, 4*len(col))
+	for i := range col {
+		col[i] = float64(i)
+		binary.LittleEndian.PutUint32(buf[4*i:], uint32(i))
+	}
+	spec := TimeSpec{OutputListType: Step, StepSize: 0.1}
+
+	api2, err := NewRawData([]DataBlock{{"a", &CountData{Col: [][]float64{col},
+		DataTypes: []uint16{IntType}}}}, spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the layout of a single data block is independent of the stream block
+	// size, small stream blocks make row ranges cross their boundaries
+	api2.OutputBufSize = 4
+	api1 := &RawData{
+		Buffer:         buf,
+		OutputListType: Step,
+		BlockSize:      uint64(len(col)),
+		StepSize:       0.1,
+		NumBlocks:      1,
+		BlockNames:     []string{"a"},
+		BlockNameMap:   map[string]uint64{"a": 0},
+		API:            API1,
+		API1Data:       API1Data{BlockEntries: []BlockEntry{{0, 0, uint64(len(buf))}}},
+	}
+	return map[string]*MCellData{"API1": FromRaw(api1), "API2": FromRaw(api2)}
+}
+
+// TestRowsInTimeRange checks the rows selected by time windows including
+// empty windows and windows bounded by output times subject to round-off
+func TestRowsInTimeRange(t *testing.T) {
+	d := rowsFixture(t)["API2"]
+	tests := []struct {
+		name         string
+		tStart, tEnd float64
+		from, to     uint64
+	}{
+		{"all", math.Inf(-1), math.Inf(1), 0, 10},
+		{"single", 0.3, 0.3, 3, 4},
+		{"round-off", 0.3, 0.7, 3, 8},
+		{"between", 0.31, 0.39, 4, 4},
+		{"reversed", 0.5, 0.2, 5, 5},
+		{"before", -1, -0.5, 0, 0},
+		{"after", 2, 3, 10, 10},
+		{"beyond end", 0.5, 100, 5, 10},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			from, to := d.RowsInTimeRange(tt.tStart, tt.tEnd)
+			if from != tt.from || to != tt.to {
+				t.Errorf("got rows [%d, %d), want [%d, %d)", from, to, tt.from, tt.to)
+			}
+		})
+	}
+}
+
+// TestBlockDataByIDRows checks the rows returned for valid and invalid row
+// ranges and strides
+func TestBlockDataByIDRows(t *testing.T) {
+	tests := []struct {
+		name             string
+		from, to, stride uint64
+		want             []float64
+		kind             error
+	}{
+		{"all", 0, 10, 1, []float64{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}, nil},
+		{"stride", 1, 10, 3, []float64{1, 4, 7}, nil},
+		{"large stride", 1, 3, 5, []float64{1}, nil},
+		{"empty", 4, 4, 1, []float64{}, nil},
+		{"to beyond end", 5, 11, 1, nil, ErrOutOfRange},
+		{"reversed", 5, 4, 1, nil, ErrOutOfRange},
+		{"stride 0", 0, 10, 0, nil, ErrOutOfRange},
+	}
+	for api, d := range rowsFixture(t) {
+		for _, tt := range tests {
+			t.Run(api+" "+tt.name, func(t *testing.T) {
+				c, err := d.BlockDataByIDRows(0, tt.from, tt.to, tt.stride)
+				if tt.kind != nil {
+					if !errors.Is(err, tt.kind) {
+						t.Errorf("got error %v, want %v", err, tt.kind)
+					}
+					return
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !reflect.DeepEqual(c.Col[0], tt.want) || !c.IsInt(0) {
+					t.Errorf("got rows %v, want %v", c.Col[0], tt.want)
+				}
+			})
+		}
+	}
+
+	d := rowsFixture(t)["API2"]
+	if _, err := d.BlockDataByIDRows(1, 0, 1, 1); !errors.Is(err, ErrOutOfRange) {
+		t.Errorf("got error %v for invalid data block, want %v", err, ErrOutOfRange)
+	}
+}
@@ -0,0 +1,190 @@
+package libmbd
+
+import (
+	"sort"
+
+	"github.com/haskelladdict/mbdr/parser/util"
+)
+
+// Validate checks the structure of the data against its header and returns
+// a list of all detected problems (or nil if there are none). dataLen is the
+// length of the count data section following the header in bytes (i.e. the
+// length of Buffer for fully read data). The checks include the length of the
+// data section, duplicate block names, the length of the output time list,
+// unknown data types, and for API version 1 overlaps and gaps between the
+// ranges of data blocks.
+func (d *MCellData) Validate(dataLen uint64) []error {
+	var errs []error
+
+	if uint64(len(d.blockNames)) != d.numBlocks {
+		errs = append(errs, d.newError(ErrCorrupt, "header lists %d data blocks "+
+			"but %d block names", d.numBlocks, len(d.blockNames)))
+	}
+
+	seen := make(map[string]bool)
+	for i, n := range d.blockNames {
+		if seen[n] {
+			errs = append(errs, d.blockError(ErrCorrupt, uint64(i),
+				"duplicate data block name %s", n))
+		}
+		seen[n] = true
+	}
+
+	switch d.outputListType {
+	case Step:
+		if d.stepSize <= 0 {
+			errs = append(errs, d.newError(ErrCorrupt, "invalid output step size %g",
+				d.stepSize))
+		}
+	case TimeListType, IterationListType:
+		if uint64(len(d.timeList)) != d.blockSize {
+			errs = append(errs, d.newError(ErrCorrupt, "output time list has %d "+
+				"entries but data blocks have %d output iterations", len(d.timeList),
+				d.blockSize))
+		}
+	default:
+		errs = append(errs, d.newError(ErrUnknownOutputType, "unknown output type %d",
+			d.outputListType))
+	}
+
+	switch d.api {
+	case API1:
+		errs = append(errs, d.validateAPI1(dataLen)...)
+	case API2, ASCII:
+		errs = append(errs, d.validateAPI2(dataLen)...)
+	default:
+		errs = append(errs, d.newError(ErrUnknownAPI, "unknown API type %s", d.api))
+	}
+	return errs
+}
+
+// validateAPI1 checks the data type and range of each data block entry as well
+// as the total length of the data section for API version 1 data
+func (d *MCellData) validateAPI1(dataLen uint64) []error {
+	var errs []error
+
+	if uint64(len(d.blockEntries)) != d.numBlocks {
+		errs = append(errs, d.newError(ErrCorrupt, "header lists %d data blocks "+
+			"but %d block entries", d.numBlocks, len(d.blockEntries)))
+	}
+
+	ids := make([]int, len(d.blockEntries))
+	for i, e := range d.blockEntries {
+		ids[i] = i
+		var itemLen uint64
+		switch e.Type {
+		case 0:
+			itemLen = util.LenUint32
+		case 1:
+			itemLen = util.LenFloat64
+		default:
+			errs = append(errs, d.blockError(ErrUnknownDataType, uint64(i),
+				"data block %d has unknown data type %d", i, e.Type))
+			continue
+		}
+		if e.End < e.Start || e.End-e.Start != d.blockSize*itemLen {
+			errs = append(errs, d.blockError(ErrCorrupt, uint64(i), "data block %d spans "+
+				"bytes %d-%d, expected length %d", i, e.Start, e.End, d.blockSize*itemLen))
+		}
+	}
+	if len(ids) == 0 {
+		if dataLen != 0 {
+			errs = append(errs, d.newError(ErrCorrupt, "found %d bytes of data but "+
+				"no data blocks", dataLen))
+		}
+		return errs
+	}
+
+	// check for overlaps and gaps between consecutive data blocks
+	entries := d.blockEntries
+	sort.Slice(ids, func(i, j int) bool {
+		return entries[ids[i]].Start < entries[ids[j]].Start
+	})
+	if entries[ids[0]].Start != d.offset {
+		errs = append(errs, d.blockError(ErrCorrupt, uint64(ids[0]), "data block %d "+
+			"starts at byte %d but data begin at byte %d", ids[0], entries[ids[0]].Start,
+			d.offset))
+	}
+	for i := 1; i < len(ids); i++ {
+		prev, cur := entries[ids[i-1]], entries[ids[i]]
+		if cur.Start < prev.End {
+			errs = append(errs, d.blockError(ErrCorrupt, uint64(ids[i]),
+				"data blocks %d and %d overlap", ids[i-1], ids[i]))
+		} else if cur.Start > prev.End {
+			errs = append(errs, d.blockError(ErrCorrupt, uint64(ids[i]),
+				"gap of %d bytes between data blocks %d and %d", cur.Start-prev.End,
+				ids[i-1], ids[i]))
+		}
+	}
+
+	end := entries[ids[len(ids)-1]].End
+	if end >= d.offset {
+		errs = append(errs, d.checkDataLen(end-d.offset, dataLen))
+	}
+	return compact(errs)
+}
+
+// validateAPI2 checks the data types and number of columns of each data block
+// as well as the total length of the data section for API version 2 data
+func (d *MCellData) validateAPI2(dataLen uint64) []error {
+	var errs []error
+
+	if uint64(len(d.blockInfo)) != d.numBlocks {
+		errs = append(errs, d.newError(ErrCorrupt, "header lists %d data blocks "+
+			"but %d block infos", d.numBlocks, len(d.blockInfo)))
+	}
+
+	if d.outputBufSize == 0 && d.blockSize != 0 {
+		errs = append(errs, d.newError(ErrCorrupt, "invalid output buffer size of 0"))
+	}
+
+	var totalCols uint64
+	for i, b := range d.blockInfo {
+		if b.NumCols == 0 {
+			errs = append(errs, d.blockError(ErrCorrupt, uint64(i),
+				"data block %s has no data columns", b.Name))
+		}
+		for c, t := range b.DataTypes {
+			if t != DoubleType && t != IntType {
+				errs = append(errs, d.blockError(ErrUnknownDataType, uint64(i),
+					"column %d of data block %s has unknown data type %d", c, b.Name, t))
+			}
+		}
+		totalCols += b.NumCols
+	}
+	if totalCols != d.totalNumCols {
+		errs = append(errs, d.newError(ErrCorrupt, "data blocks have %d columns in "+
+			"total but header lists %d", totalCols, d.totalNumCols))
+	}
+
+	errs = append(errs, d.checkDataLen(d.blockSize*d.totalNumCols*util.LenFloat64,
+		dataLen))
+	return compact(errs)
+}
+
+// checkDataLen compares the expected and actual length of the data section
+func (d *MCellData) checkDataLen(expected, actual uint64) error {
+	if actual < expected {
+		e := d.newError(ErrTruncated, "truncated data: expected %d bytes of count "+
+			"data but found only %d", expected, actual)
+		e.Offset = int64(actual)
+		return e
+	} else if actual > expected {
+		e := d.newError(ErrCorrupt, "found %d trailing bytes after the expected %d "+
+			"bytes of count data", actual-expected, expected)
+		e.Offset = int64(expected)
+		return e
+	}
+	return nil
+}
+
+// compact removes all nil errors from the list
+func compact(errs []error) []error {
+	var out []error
+	for _, e := range errs {
+		if e != nil {
+			out = append(out, e)
+		}
+	}
+	return out
+}
@@ -0,0 +1,101 @@
+package libmbd
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// TestValidate checks that Validate detects structural problems of API1 and
+// API2 data
+func TestValidate(t *testing.T) {
+	// two API1 data blocks with 2 integer and 2 double items starting at byte 100
+	api1 := func() (*RawData, uint64) {
+		return &RawData{
+			OutputListType: Step,
+			BlockSize:      2,
+			StepSize:       1,
+			NumBlocks:      2,
+			BlockNames:     []string{"a", "b"},
+			API:            API1,
+			API1Data: API1Data{Offset: 100,
+				BlockEntries: []BlockEntry{{0, 100, 108}, {1, 108, 124}}},
+		}, 24
+	}
+	api2 := func() (*RawData, uint64) {
+		raw, err := NewRawData([]DataBlock{{"a", &CountData{Col: [][]float64{{1, 2}},
+			DataTypes: []uint16{IntType}}}}, TimeSpec{OutputListType: Step, StepSize: 1})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return raw, uint64(len(raw.Buffer))
+	}
+
+	tests := []struct {
+		name   string
+		data   func() (*RawData, uint64)
+		modify func(raw *RawData, dataLen *uint64)
+		kind   error  // kind of the expected error (nil if valid)
+		msg    string // part of the expected error message
+	}{
+		{"API1", api1, func(*RawData, *uint64) {}, nil, ""},
+		{"API2", api2, func(*RawData, *uint64) {}, nil, ""},
+		{"API1 truncated", api1, func(_ *RawData, n *uint64) { *n = 20 }, ErrTruncated,
+			"expected 24 bytes of count data but found only 20"},
+		{"API2 truncated", api2, func(_ *RawData, n *uint64) { *n-- }, ErrTruncated,
+			"expected 16 bytes of count data but found only 15"},
+		{"API1 trailing", api1, func(_ *RawData, n *uint64) { *n = 30 }, ErrCorrupt,
+			"found 6 trailing bytes"},
+		{"API1 overlap", api1, func(r *RawData, _ *uint64) {
+			r.BlockEntries[1] = BlockEntry{1, 104, 120}
+		}, ErrCorrupt, "data blocks 0 and 1 overlap"},
+		{"API1 gap", api1, func(r *RawData, n *uint64) {
+			r.BlockEntries[1] = BlockEntry{1, 112, 128}
+			*n = 28
+		}, ErrCorrupt, "gap of 4 bytes between data blocks 0 and 1"},
+		{"API1 late start", api1, func(r *RawData, n *uint64) {
+			r.BlockEntries = []BlockEntry{{0, 104, 112}, {1, 112, 128}}
+			*n = 28
+		}, ErrCorrupt, "data block 0 starts at byte 104 but data begin at byte 100"},
+		{"API1 entry length", api1, func(r *RawData, _ *uint64) {
+			r.BlockEntries[0] = BlockEntry{0, 100, 104}
+		}, ErrCorrupt, "data block 0 spans bytes 100-104, expected length 8"},
+		{"duplicate names", api1, func(r *RawData, _ *uint64) {
+			r.BlockNames = []string{"a", "a"}
+		}, ErrCorrupt, "duplicate data block name a"},
+		{"time list length", api1, func(r *RawData, _ *uint64) {
+			r.OutputListType, r.TimeList = TimeListType, []float64{0, 1, 2}
+		}, ErrCorrupt, "output time list has 3 entries but data blocks have 2"},
+		{"API1 data type", api1, func(r *RawData, _ *uint64) {
+			r.BlockEntries[1].Type = 7
+		}, ErrUnknownDataType, "data block 1 has unknown data type 7"},
+		{"API2 data type", api2, func(r *RawData, _ *uint64) {
+			r.BlockInfo[0].DataTypes[0] = 5
+		}, ErrUnknownDataType, "column 0 of data block a has unknown data type 5"},
+		{"output type", api2, func(r *RawData, _ *uint64) {
+			r.OutputListType = 8
+		}, ErrUnknownOutputType, "unknown output type 8"},
+		{"API", api2, func(r *RawData, _ *uint64) { r.API = "MCELL_BINARY_API_3" },
+			ErrUnknownAPI, "unknown API type MCELL_BINARY_API_3"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			raw, dataLen := tt.data()
+			tt.modify(raw, &dataLen)
+			errs := FromRaw(raw).Validate(dataLen)
+			if tt.kind == nil {
+				if len(errs) != 0 {
+					t.Errorf("valid data have problems: %v", errs)
+				}
+				return
+			}
+
+			for _, err := range errs {
+				if errors.Is(err, tt.kind) && strings.Contains(err.Error(), tt.msg) {
+					return
+				}
+			}
+			t.Errorf("got problems %v, want %v error containing %q", errs, tt.kind, tt.msg)
+		})
+	}
+}
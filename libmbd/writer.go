@@ -0,0 +1,305 @@
+package libmbd
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+
+	"github.com/haskelladdict/mbdr/parser/util"
+)
+
+// DefaultOutputBufSize is the number of rows per stream block used when
+// writing data which don't specify an output buffer size (e.g. API1 data)
+const DefaultOutputBufSize = 10000
+
+// DataBlock is a named data block to be written to a binary mcell file
+type DataBlock struct {
+	Name string
+	Data *CountData
+}
+
+// TimeSpec describes the output times of the data blocks within a binary
+// mcell file (either via STEP or via ITERATION_LIST/TIME_LIST)
+type TimeSpec struct {
+	OutputListType uint16
+	StepSize       float64
+	TimeList       []float64
+}
+
+// TimeSpec returns the description of the output times of the data. The
+// time list is a copy which may be modified by the caller.
+func (d *MCellData) TimeSpec() TimeSpec {
+	spec := TimeSpec{OutputListType: d.outputListType, StepSize: d.stepSize}
+	if d.outputListType != Step {
+		spec.TimeList = append([]float64(nil), d.timeList...)
+	}
+	return spec
+}
+
+// DataBlocks returns all data blocks of MCellData in order of their IDs
+func (d *MCellData) DataBlocks() ([]DataBlock, error) {
+	blocks := make([]DataBlock, 0, d.numBlocks)
+	for id := uint64(0); id < d.numBlocks; id++ {
+		countData, err := d.BlockDataByID(id)
+		if err != nil {
+			return nil, err
+		}
+		blocks = append(blocks, DataBlock{d.blockNames[id], countData})
+	}
+	return blocks, nil
+}
+
+// WriteAPI2 writes all data blocks of MCellData to w according to API version
+// MCELL_BINARY_API_2. The output buffer size of the original data is retained
+// if available. Since this requires decoding all data blocks the complete
+// data set is held in memory during writing.
+func (d *MCellData) WriteAPI2(w io.Writer) error {
+	blocks, err := d.DataBlocks()
+	if err != nil {
+		return err
+	}
+
+	bufSize := d.outputBufSize
+	if bufSize == 0 {
+		bufSize = DefaultOutputBufSize
+	}
+	return WriteAPI2(w, blocks, d.TimeSpec(), bufSize)
+}
+
+// WriteAPI2 writes the provided data blocks and time specification to w
+// according to API version MCELL_BINARY_API_2. The count data are split into
+// stream blocks of outputBufSize rows each. All data blocks need to have the
+// same number of rows and a data type for each column. The output is not
+// compressed.
+func WriteAPI2(w io.Writer, blocks []DataBlock, spec TimeSpec, outputBufSize uint64) error {
+	if outputBufSize == 0 {
+		return fmt.Errorf("output buffer size has to be positive")
+	}
+	blockSize, err := numRows(blocks)
+	if err != nil {
+		return err
+	}
+
+	bw := bufio.NewWriter(w)
+	if err := writeHeaderAPI2(bw, blocks, spec, blockSize, outputBufSize); err != nil {
+		return err
+	}
+	if err := writeDataAPI2(bw, blocks, blockSize, outputBufSize); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// NewMCellData returns MCellData holding the provided data blocks in memory
+// using the layout of API version MCELL_BINARY_API_2 with all rows in a single
+// stream block. This allows data assembled in memory (e.g. by Merge) to be
+// accessed and written like data read from a file (e.g. by Resample).
+func NewMCellData(blocks []DataBlock, spec TimeSpec) (*MCellData, error) {
+	raw, err := NewRawData(blocks, spec)
+	if err != nil {
+		return nil, err
+	}
+	return FromRaw(raw), nil
+}
+
+// NewRawData is like NewMCellData but returns the RawData. This allows
+// adjusting e.g. the name of the data file before calling FromRaw.
+func NewRawData(blocks []DataBlock, spec TimeSpec) (*RawData, error) {
+	blockSize, err := numRows(blocks)
+	if err != nil {
+		return nil, err
+	}
+
+	var numCols uint64
+	for _, b := range blocks {
+		numCols += uint64(len(b.Data.Col))
+	}
+	buf := bytes.NewBuffer(make([]byte, 0, blockSize*numCols*util.LenFloat64))
+	if err := writeDataAPI2(buf, blocks, blockSize, blockSize); err != nil {
+		return nil, err
+	}
+	return inMemory(buf.Bytes(), blocks, blockSize, spec), nil
+}
+
+// inMemory returns RawData for count data held in buf using the layout of
+// API version MCELL_BINARY_API_2 with all blockSize rows in a single stream
+// block. The names, data types, and metadata of the data blocks are taken from
+// blocks whose columns are not accessed.
+func inMemory(buf []byte, blocks []DataBlock, blockSize uint64,
+	spec TimeSpec) *RawData {
+
+	outputBufSize := blockSize
+	if outputBufSize == 0 {
+		outputBufSize = DefaultOutputBufSize
+	}
+
+	d := &RawData{
+		Buffer:         buf,
+		OutputListType: spec.OutputListType,
+		BlockSize:      blockSize,
+		StepSize:       spec.StepSize,
+		NumBlocks:      uint64(len(blocks)),
+		BlockNameMap:   make(map[string]uint64),
+		API:            API2,
+	}
+	if spec.OutputListType != Step {
+		d.TimeList = append([]float64(nil), spec.TimeList...)
+	}
+	d.OutputBufSize = outputBufSize
+	for i, b := range blocks {
+		d.BlockNames = append(d.BlockNames, b.Name)
+		d.BlockNameMap[b.Name] = uint64(i)
+		d.BlockInfo = append(d.BlockInfo, BlockData{
+			Name:      b.Name,
+			NumCols:   uint64(len(b.Data.DataTypes)),
+			DataTypes: append([]uint16(nil), b.Data.DataTypes...),
+			Offset:    d.TotalNumCols,
+		})
+		d.TotalNumCols += uint64(len(b.Data.DataTypes))
+		if !b.Data.Meta.Empty() {
+			if d.Meta == nil {
+				d.Meta = make([]BlockMeta, len(blocks))
+			}
+			d.Meta[i] = b.Data.Meta.clone()
+		}
+	}
+	return d
+}
+
+// NewTimeSpec returns the time specification for the provided output times.
+// Equidistant output times starting at 0 are described via STEP, all others
+// via TIME_LIST.
+func NewTimeSpec(times []float64) TimeSpec {
+	if len(times) >= 2 && times[0] == 0 && times[1] > 0 {
+		step := times[1]
+		equidistant := true
+		for i, t := range times {
+			if math.Abs(t-float64(i)*step) > 1e-6*step {
+				equidistant = false
+				break
+			}
+		}
+		if equidistant {
+			return TimeSpec{OutputListType: Step, StepSize: step}
+		}
+	}
+	return TimeSpec{OutputListType: TimeListType, TimeList: times}
+}
+
+// numRows checks that all data blocks have a data type for each column and
+// the same number of rows and returns the latter
+func numRows(blocks []DataBlock) (uint64, error) {
+	var blockSize uint64
+	for i, b := range blocks {
+		if b.Data == nil || len(b.Data.Col) == 0 {
+			return 0, fmt.Errorf("data block %s has no data columns", b.Name)
+		}
+		if len(b.Data.DataTypes) != len(b.Data.Col) {
+			return 0, fmt.Errorf("data block %s has %d columns but %d data types", b.Name,
+				len(b.Data.Col), len(b.Data.DataTypes))
+		}
+		if i == 0 {
+			blockSize = uint64(len(b.Data.Col[0]))
+		}
+		for _, c := range b.Data.Col {
+			if uint64(len(c)) != blockSize {
+				return 0, fmt.Errorf("data block %s has %d rows, expected %d", b.Name,
+					len(c), blockSize)
+			}
+		}
+	}
+	return blockSize, nil
+}
+
+// writeDataAPI2 writes the count data of the provided data blocks in stream
+// blocks of outputBufSize rows each. Each stream block holds all rows of the
+// first data block followed by all rows of the next data block etc.
+func writeDataAPI2(w io.Writer, blocks []DataBlock, blockSize,
+	outputBufSize uint64) error {
+
+	buf := make([]byte, util.LenFloat64)
+	for row := uint64(0); row < blockSize; row += outputBufSize {
+		numRows := outputBufSize
+		if blockSize-row < outputBufSize {
+			numRows = blockSize - row
+		}
+		for _, b := range blocks {
+			for r := row; r < row+numRows; r++ {
+				for _, c := range b.Data.Col {
+					binary.LittleEndian.PutUint64(buf, math.Float64bits(c[r]))
+					if _, err := w.Write(buf); err != nil {
+						return err
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// writeHeaderAPI2 writes the API tag and header describing the provided data
+// blocks
+func writeHeaderAPI2(w io.Writer, blocks []DataBlock, spec TimeSpec, blockSize,
+	outputBufSize uint64) error {
+
+	// NOTE: The leading byte is a defect in the mcell binary output format
+	// which all readers expect to be present
+	if _, err := io.WriteString(w, API2+"\x00"); err != nil {
+		return err
+	}
+
+	if err := util.WriteUint16(w, spec.OutputListType); err != nil {
+		return err
+	}
+	if err := util.WriteUint64(w, blockSize); err != nil {
+		return err
+	}
+
+	switch spec.OutputListType {
+	case Step:
+		if err := util.WriteUint64(w, 1); err != nil {
+			return err
+		}
+		if err := util.WriteFloat64(w, spec.StepSize); err != nil {
+			return err
+		}
+
+	case TimeListType, IterationListType:
+		if err := util.WriteUint64(w, uint64(len(spec.TimeList))); err != nil {
+			return err
+		}
+		for _, t := range spec.TimeList {
+			if err := util.WriteFloat64(w, t); err != nil {
+				return err
+			}
+		}
+
+	default:
+		return NewError(ErrUnknownOutputType, "encountered unknown data output type")
+	}
+
+	if err := util.WriteUint64(w, outputBufSize); err != nil {
+		return err
+	}
+	if err := util.WriteUint64(w, uint64(len(blocks))); err != nil {
+		return err
+	}
+
+	for _, b := range blocks {
+		if _, err := io.WriteString(w, b.Name+"\x00"); err != nil {
+			return err
+		}
+		if err := util.WriteUint64(w, uint64(len(b.Data.Col))); err != nil {
+			return err
+		}
+		for _, t := range b.Data.DataTypes {
+			if err := util.WriteUint16(w, t); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
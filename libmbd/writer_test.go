@@ -0,0 +1,35 @@
+package libmbd
+
+import (
+	"reflect"
+	"testing"
+)
+
+// TestNewTimeSpec checks that equidistant output times starting at 0 are
+// described via STEP and all others via TIME_LIST
+func TestNewTimeSpec(t *testing.T) {
+	timeList := func(times ...float64) TimeSpec {
+		return TimeSpec{OutputListType: TimeListType, TimeList: times}
+	}
+	tests := []struct {
+		name  string
+		times []float64
+		want  TimeSpec
+	}{
+		{"step", []float64{0, 1e-6, 2e-6, 3e-6}, TimeSpec{OutputListType: Step,
+			StepSize: 1e-6}},
+		{"round-off", []float64{0, 0.1, 0.2, 0.30000000000000004}, TimeSpec{
+			OutputListType: Step, StepSize: 0.1}},
+		{"offset", []float64{1, 2, 3}, timeList(1, 2, 3)},
+		{"not equidistant", []float64{0, 1, 3}, timeList(0, 1, 3)},
+		{"single", []float64{0}, timeList(0)},
+		{"decreasing", []float64{0, -1, -2}, timeList(0, -1, -2)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := NewTimeSpec(tt.times); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,133 @@
+package parser
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/haskelladdict/mbdr/libmbd"
+	"github.com/haskelladdict/mbdr/parser/parseASCII"
+)
+
+// asciiSuffix is the file extension of MCell ASCII reaction data files
+const asciiSuffix = ".dat"
+
+// isASCII tests if the named path refers to MCell ASCII reaction data output,
+// i.e. either a directory or a file with the .dat extension
+func isASCII(path string) bool {
+	if strings.HasSuffix(path, asciiSuffix) {
+		return true
+	}
+	info, err := os.Stat(path)
+	return err == nil && info.IsDir()
+}
+
+// ReadASCII reads MCell ASCII reaction data output and returns it as
+// MCellData. Each path is either a reaction data file or a directory in which
+// case all .dat files within it are read. The data blocks are named after the
+// base names of the files and the output times are taken from the first column
+// of each file which have to agree across all files. Equidistant output times
+// starting at 0 are reported as STEP output, all others as TIME_LIST.
+// NOTE: The data are held in memory using the layout of MCELL_BINARY_API_2
+// with the API field set to libmbd.ASCII.
+func ReadASCII(paths ...string) (*libmbd.MCellData, error) {
+	raw, err := readASCII(paths)
+	if err != nil {
+		return nil, err
+	}
+	return libmbd.FromRaw(raw), nil
+}
+
+// readASCII reads MCell ASCII reaction data output. See ReadASCII for
+// details.
+func readASCII(paths []string) (*libmbd.RawData, error) {
+	fileNames, err := asciiFiles(paths)
+	if err != nil {
+		return nil, err
+	}
+	if len(fileNames) == 0 {
+		return nil, libmbd.NewError(libmbd.ErrEmpty, "no reaction data files found")
+	}
+
+	var times []float64
+	blocks := make([]libmbd.DataBlock, 0, len(fileNames))
+	for _, n := range fileNames {
+		t, countData, err := readASCIIFile(n)
+		if err != nil {
+			return nil, err
+		}
+		if times == nil {
+			times = t
+		} else if !sameTimes(times, t) {
+			return nil, libmbd.WithFile(libmbd.NewError(libmbd.ErrCorrupt,
+				"output times differ from those of %s", fileNames[0]), n)
+		}
+		blocks = append(blocks, libmbd.DataBlock{Name: filepath.Base(n), Data: countData})
+	}
+
+	raw, err := libmbd.NewRawData(blocks, libmbd.NewTimeSpec(times))
+	if err != nil {
+		return nil, err
+	}
+	raw.API = libmbd.ASCII
+	if len(paths) == 1 {
+		raw.FileName = paths[0]
+	}
+	return raw, nil
+}
+
+// asciiFiles expands the provided list of files and directories into a list
+// of reaction data files. Files within directories are sorted by name.
+func asciiFiles(paths []string) ([]string, error) {
+	var fileNames []string
+	for _, p := range paths {
+		info, err := os.Stat(p)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			fileNames = append(fileNames, p)
+			continue
+		}
+
+		matches, err := filepath.Glob(filepath.Join(p, "*"+asciiSuffix))
+		if err != nil {
+			return nil, err
+		}
+		sort.Strings(matches)
+		fileNames = append(fileNames, matches...)
+	}
+	return fileNames, nil
+}
+
+// readASCIIFile reads the output times and count data of the named reaction
+// data file
+func readASCIIFile(filename string) ([]float64, *libmbd.CountData, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer file.Close()
+
+	times, countData, err := parseASCII.Data(file)
+	if err != nil {
+		return nil, nil, libmbd.WithFile(err, filename)
+	}
+	return times, countData, nil
+}
+
+// sameTimes checks if the two lists of output times agree up to the precision
+// of the ASCII output
+func sameTimes(a, b []float64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if math.Abs(a[i]-b[i]) > 1e-9*math.Max(math.Abs(a[i]), math.Abs(b[i])) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,134 @@
+package parser
+
+import (
+	"errors"
+	"math"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/haskelladdict/mbdr/libmbd"
+)
+
+// TestReadASCII checks the data blocks, output times, and values read from
+// the reaction data files in testdata/ascii
+func TestReadASCII(t *testing.T) {
+	tests := []struct {
+		path      string
+		names     []string
+		spec      libmbd.TimeSpec
+		times     []float64
+		blocks    []*libmbd.CountData
+		fileName  string
+		wantError error
+	}{
+		{"testdata/ascii/step", []string{"ca.dat", "vesicle.dat"},
+			libmbd.TimeSpec{OutputListType: libmbd.Step, StepSize: 1e-6},
+			[]float64{0, 1e-6, 2e-6, 3e-6, 4e-6},
+			[]*libmbd.CountData{
+				{Col: [][]float64{{12, 10, 9, 11, 8}}, DataTypes: []uint16{libmbd.IntType}},
+				{Col: [][]float64{{0, 1, 2, 2, 3}, {0.5, 0.25, 0.125, 0.0625, 0.03125}},
+					DataTypes: []uint16{libmbd.IntType, libmbd.DoubleType}},
+			}, "testdata/ascii/step", nil},
+		{"testdata/ascii/step/vesicle.dat", []string{"vesicle.dat"},
+			libmbd.TimeSpec{OutputListType: libmbd.Step, StepSize: 1e-6},
+			[]float64{0, 1e-6, 2e-6, 3e-6, 4e-6},
+			[]*libmbd.CountData{
+				{Col: [][]float64{{0, 1, 2, 2, 3}, {0.5, 0.25, 0.125, 0.0625, 0.03125}},
+					DataTypes: []uint16{libmbd.IntType, libmbd.DoubleType}},
+			}, "testdata/ascii/step/vesicle.dat", nil},
+		{"testdata/ascii/list", []string{"ca.dat"},
+			libmbd.TimeSpec{OutputListType: libmbd.TimeListType,
+				TimeList: []float64{0.001, 0.002, 0.004}},
+			[]float64{0.001, 0.002, 0.004},
+			[]*libmbd.CountData{
+				{Col: [][]float64{{4, 5, 6}}, DataTypes: []uint16{libmbd.IntType}},
+			}, "testdata/ascii/list", nil},
+		{"testdata/ascii/mismatch", nil, libmbd.TimeSpec{}, nil, nil, "",
+			libmbd.ErrCorrupt},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			data, err := ReadASCII(tt.path)
+			if tt.wantError != nil {
+				if !errors.Is(err, tt.wantError) {
+					t.Errorf("got error %v, want %v", err, tt.wantError)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer data.Close()
+
+			if data.API() != libmbd.ASCII || data.FileName() != tt.fileName {
+				t.Errorf("got API %s of file %q, want %s of %q", data.API(), data.FileName(),
+					libmbd.ASCII, tt.fileName)
+			}
+			if names := data.DataNames(); !reflect.DeepEqual(names, tt.names) {
+				t.Errorf("got data blocks %v, want %v", names, tt.names)
+			}
+			if spec := data.TimeSpec(); !reflect.DeepEqual(spec, tt.spec) {
+				t.Errorf("got time spec %+v, want %+v", spec, tt.spec)
+			}
+			times := data.OutputTimes()
+			if len(times) != len(tt.times) {
+				t.Fatalf("got %d output times, want %d", len(times), len(tt.times))
+			}
+			for i := range times {
+				if math.Abs(times[i]-tt.times[i]) > 1e-15 {
+					t.Errorf("got output time %g, want %g", times[i], tt.times[i])
+				}
+			}
+			for id, want := range tt.blocks {
+				got, err := data.BlockDataByID(uint64(id))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !reflect.DeepEqual(got.Col, want.Col) ||
+					!reflect.DeepEqual(got.DataTypes, want.DataTypes) {
+					t.Errorf("data block %s: got %+v, want %+v", tt.names[id], got, want)
+				}
+			}
+		})
+	}
+}
+
+// TestASCIIPaths checks that directories and .dat files are read as MCell
+// ASCII reaction data output by all readers
+func TestASCIIPaths(t *testing.T) {
+	readers := map[string]func(string) (*libmbd.MCellData, error){
+		"Read":       Read,
+		"ReadHeader": ReadHeader,
+		"ReadLazy":   ReadLazy,
+		"ReadSelected": func(path string) (*libmbd.MCellData, error) {
+			return ReadSelected(path, SelectNames("ca.dat"))
+		},
+		"Inspect": func(path string) (*libmbd.MCellData, error) {
+			data, _, err := Inspect(path)
+			return data, err
+		},
+	}
+	for name, read := range readers {
+		for _, path := range []string{"testdata/ascii/step",
+			filepath.Join("testdata", "ascii", "step", "ca.dat")} {
+
+			data, err := read(path)
+			if err != nil {
+				t.Errorf("%s(%s): %s", name, path, err)
+				continue
+			}
+			if data.API() != libmbd.ASCII {
+				t.Errorf("%s(%s): got API %s, want %s", name, path, data.API(), libmbd.ASCII)
+			}
+			if _, err := data.BlockNameToID("ca.dat"); err != nil {
+				t.Errorf("%s(%s): %s", name, path, err)
+			}
+			data.Close()
+		}
+	}
+
+	if c, err := Compression("testdata/ascii/step"); err != nil || c != "none" {
+		t.Errorf("got compression %q (%v) of ASCII data, want none", c, err)
+	}
+}
@@ -0,0 +1,309 @@
+// Package bzindex locates the independent blocks within bzip2 compressed
+// streams and decodes them individually. This provides random access into
+// bzip2 compressed mcell binary data without having to decompress (and keep)
+// the complete data set.
+package bzindex
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+)
+
+// bzip2 block and end of stream magic numbers
+const (
+	blockMagic = 0x314159265359
+	finalMagic = 0x177245385090
+	magicMask  = 1<<48 - 1
+)
+
+// streamHeader is prepended to each individually decoded block. We always
+// request the maximum block size of 900k since the block size of the original
+// stream is only an upper limit for the size of each block.
+var streamHeader = []byte("BZh9")
+
+// Block describes a single bzip2 block within a compressed stream in terms of
+// the range of bits it occupies in the compressed stream and the byte range of
+// its decompressed data
+type Block struct {
+	BitStart int64 // offset of the block magic in the compressed stream (in bits)
+	BitEnd   int64 // offset of the first bit following the block (in bits)
+	Offset   int64 // offset of the block's data in the decompressed stream
+	Size     int64 // size of the block's decompressed data
+}
+
+// Index is the list of all blocks within a (possibly multi-stream) bzip2
+// compressed file and the total size of the decompressed data
+type Index struct {
+	Blocks []Block
+	Size   int64
+}
+
+// Scan locates the bit ranges of all candidate blocks within the bzip2
+// compressed stream r. Since block magics are not byte aligned and could in
+// principle also appear within compressed data, the returned blocks have not
+// been validated and their decompressed offset and size are not set.
+// NOTE: Multi-stream files such as the ones created by pbzip2 are supported
+// since each stream ends with a final magic which also terminates the
+// preceding block.
+func Scan(r io.Reader) ([]Block, error) {
+	br := bufio.NewReaderSize(r, 1<<16)
+
+	var blocks []Block
+	var reg uint64
+	var pos int64 // number of bits consumed so far
+	open := false
+	for {
+		c, err := br.ReadByte()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		for i := 7; i >= 0; i-- {
+			reg = reg<<1 | uint64(c>>uint(i))&1
+			pos++
+			if pos < 48 {
+				continue
+			}
+
+			switch reg & magicMask {
+			case blockMagic:
+				if open {
+					blocks[len(blocks)-1].BitEnd = pos - 48
+				}
+				blocks = append(blocks, Block{BitStart: pos - 48})
+				open = true
+
+			case finalMagic:
+				if open {
+					blocks[len(blocks)-1].BitEnd = pos - 48
+				}
+				open = false
+			}
+		}
+	}
+
+	if open {
+		return nil, fmt.Errorf("bzip2 stream is truncated")
+	}
+	return blocks, nil
+}
+
+// Build creates the index for the bzip2 compressed data of the given size
+// provided via r. Each block is decoded once to determine the size of its
+// decompressed data. Candidate blocks which fail to decode are the result of
+// spurious block magics within compressed data and are merged with their
+// successor.
+func Build(r io.ReaderAt, size int64) (*Index, error) {
+	candidates, err := Scan(io.NewSectionReader(r, 0, size))
+	if err != nil {
+		return nil, err
+	}
+
+	var idx Index
+	for i := 0; i < len(candidates); i++ {
+		b := candidates[i]
+		var data []byte
+		for {
+			if data, err = decode(r, b); err == nil {
+				break
+			}
+			// merge with the next candidate if the two are adjacent
+			if i+1 >= len(candidates) || candidates[i+1].BitStart != b.BitEnd {
+				return nil, err
+			}
+			i++
+			b.BitEnd = candidates[i].BitEnd
+		}
+		b.Offset = idx.Size
+		b.Size = int64(len(data))
+		idx.Blocks = append(idx.Blocks, b)
+		idx.Size += b.Size
+	}
+	return &idx, nil
+}
+
+// Decode decompresses the data of the given block into dst which has to be
+// large enough to hold b.Size bytes
+func Decode(r io.ReaderAt, b Block, dst []byte) error {
+	stream, err := blockStream(r, b)
+	if err != nil {
+		return err
+	}
+	n, err := io.ReadFull(bzip2.NewReader(bytes.NewReader(stream)), dst[:b.Size])
+	if err != nil {
+		return err
+	}
+	if int64(n) != b.Size {
+		return fmt.Errorf("bzip2 block at bit %d has unexpected length", b.BitStart)
+	}
+	return nil
+}
+
+// decode decompresses the data of a block of unknown size
+func decode(r io.ReaderAt, b Block) ([]byte, error) {
+	stream, err := blockStream(r, b)
+	if err != nil {
+		return nil, err
+	}
+	return decodeStream(stream)
+}
+
+// decodeStream decompresses a complete bzip2 stream
+func decodeStream(stream []byte) ([]byte, error) {
+	var out bytes.Buffer
+	if _, err := out.ReadFrom(bzip2.NewReader(bytes.NewReader(stream))); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// blockStream assembles a self-contained bzip2 stream consisting of the
+// given block only
+func blockStream(r io.ReaderAt, b Block) ([]byte, error) {
+	first := b.BitStart / 8
+	last := (b.BitEnd + 7) / 8
+	if last < first {
+		return nil, fmt.Errorf("bzip2 block at bit %d is too short", b.BitStart)
+	}
+	raw := make([]byte, last-first)
+	n, err := r.ReadAt(raw, first)
+	if err != nil && !(err == io.EOF && int64(n) == last-first) {
+		return nil, err
+	}
+	return assemble(raw, b.BitStart, b.BitEnd)
+}
+
+// assemble turns the bits [bitStart, bitEnd) of a bzip2 stream into a
+// self-contained bzip2 stream consisting of this block only. raw contains the
+// bytes of the original stream starting with the byte holding bitStart.
+// Since the file checksum of a single block stream is identical to the block's
+// checksum the result can be decoded by compress/bzip2.
+func assemble(raw []byte, bitStart, bitEnd int64) ([]byte, error) {
+	numBits := bitEnd - bitStart
+	if numBits < 80 {
+		return nil, fmt.Errorf("bzip2 block at bit %d is too short", bitStart)
+	}
+
+	// shift block bits to the beginning of a byte
+	shift := uint(bitStart % 8)
+	numBytes := (numBits + 7) / 8
+	w := bitWriter{buf: make([]byte, len(streamHeader), int64(len(streamHeader))+numBytes+11)}
+	copy(w.buf, streamHeader)
+	for i := int64(0); i < numBytes; i++ {
+		var next byte
+		if i+1 < int64(len(raw)) {
+			next = raw[i+1]
+		}
+		w.buf = append(w.buf, raw[i]<<shift|next>>(8-shift))
+	}
+	if rem := uint(numBits % 8); rem != 0 {
+		w.buf[len(w.buf)-1] &= 0xff << (8 - rem)
+		w.bits = rem
+	}
+
+	// block checksum follows the 48 bit block magic
+	crc := uint64(0)
+	for _, c := range w.buf[len(streamHeader)+6 : len(streamHeader)+10] {
+		crc = crc<<8 | uint64(c)
+	}
+	w.write(finalMagic, 48)
+	w.write(crc, 32)
+	return w.buf, nil
+}
+
+// bitWriter appends bits in MSB first order to a byte slice. bits is the
+// number of bits already used in the last byte of buf (0 if it is full).
+type bitWriter struct {
+	buf  []byte
+	bits uint
+}
+
+// write appends the n least significant bits of v
+func (w *bitWriter) write(v uint64, n uint) {
+	for i := int(n) - 1; i >= 0; i-- {
+		if w.bits == 0 {
+			w.buf = append(w.buf, 0)
+		}
+		w.buf[len(w.buf)-1] |= byte((v>>uint(i))&1) << (7 - w.bits)
+		w.bits = (w.bits + 1) % 8
+	}
+}
+
+// Reader provides random access to the decompressed data of an indexed
+// bzip2 stream. Only the blocks overlapping a requested byte range are
+// decoded and the most recently used block is cached. Reader is safe for
+// concurrent use and concurrent reads decode their blocks in parallel.
+type Reader struct {
+	r   io.ReaderAt
+	idx *Index
+
+	mu    sync.Mutex
+	block int
+	data  []byte // decoded data of block, never modified once cached
+}
+
+// NewReader returns a Reader for the bzip2 compressed data in r described by
+// the given index
+func NewReader(r io.ReaderAt, idx *Index) *Reader {
+	return &Reader{r: r, idx: idx, block: -1}
+}
+
+// Size returns the size of the decompressed data
+func (z *Reader) Size() int64 {
+	return z.idx.Size
+}
+
+// ReadAt implements io.ReaderAt for the decompressed data
+func (z *Reader) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, fmt.Errorf("bzindex: negative offset")
+	}
+
+	blocks := z.idx.Blocks
+	n := 0
+	for n < len(p) {
+		pos := off + int64(n)
+		if pos >= z.idx.Size {
+			return n, io.EOF
+		}
+		i := sort.Search(len(blocks), func(i int) bool {
+			return blocks[i].Offset+blocks[i].Size > pos
+		})
+		data, err := z.blockData(i)
+		if err != nil {
+			return n, err
+		}
+		n += copy(p[n:], data[pos-blocks[i].Offset:])
+	}
+	return n, nil
+}
+
+// blockData returns the decoded data of block i either from the cache or by
+// decoding it. Decoding happens outside of the lock so that concurrent
+// readers don't have to wait for each other.
+func (z *Reader) blockData(i int) ([]byte, error) {
+	z.mu.Lock()
+	if z.block == i {
+		data := z.data
+		z.mu.Unlock()
+		return data, nil
+	}
+	z.mu.Unlock()
+
+	data := make([]byte, z.idx.Blocks[i].Size)
+	if err := Decode(z.r, z.idx.Blocks[i], data); err != nil {
+		return nil, err
+	}
+
+	z.mu.Lock()
+	z.block, z.data = i, data
+	z.mu.Unlock()
+	return data, nil
+}
@@ -0,0 +1,302 @@
+package bzindex
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"fmt"
+	"io"
+	"runtime"
+	"sync"
+)
+
+// maxIdleBytes is the number of compressed bytes kept while scanning outside
+// of a block (e.g. between concatenated streams) before they are discarded
+const maxIdleBytes = 1 << 16
+
+// maxBlockBits is an upper limit for the compressed size of a bzip2 block in
+// bits: at most 900000 symbols plus end of block with codes of up to 20 bits
+// each as well as the coding tables and selectors
+const maxBlockBits = 20*900001 + 1<<18
+
+// job is a single bzip2 block to be decoded. raw holds the compressed bytes
+// starting with the byte containing bitStart.
+type job struct {
+	raw              []byte
+	bitStart, bitEnd int64
+	out              chan result
+}
+
+// result is a decoded job or an error. Errors with a nil job are fatal
+// errors encountered while scanning. Decoding a bzip2 block is performed in
+// two steps: The expensive Huffman decoding and inverse Burrows-Wheeler
+// transform happen concurrently when the first byte of the block is read.
+// The remaining data are produced by bz on demand and written directly into
+// the buffer passed to Read.
+type result struct {
+	bz    io.Reader
+	first []byte // data read from bz while decoding
+	err   error
+	job   *job
+}
+
+// ParallelReader decompresses a bzip2 stream by scanning it for block
+// boundaries and decoding the blocks on a pool of goroutines. The
+// decompressed data are returned in order. Multi-stream files such as the
+// ones created by pbzip2 are supported. The number of blocks in flight, and
+// thus memory use, is proportional to the number of goroutines.
+type ParallelReader struct {
+	results chan chan result
+	done    chan struct{}
+	once    sync.Once
+
+	cur []byte // data of the current block not yet returned by Read
+	err error
+}
+
+// NewParallelReader returns a ParallelReader decompressing r using the given
+// number of goroutines. If threads <= 0 GOMAXPROCS goroutines are used.
+func NewParallelReader(r io.Reader, threads int) *ParallelReader {
+	if threads <= 0 {
+		threads = runtime.GOMAXPROCS(0)
+	}
+
+	p := &ParallelReader{
+		results: make(chan chan result, 2*threads),
+		done:    make(chan struct{}),
+	}
+	jobs := make(chan *job, threads)
+	go p.scan(r, jobs)
+	for i := 0; i < threads; i++ {
+		go p.decodeJobs(jobs)
+	}
+	return p
+}
+
+// Read implements io.Reader. The data of each block are decoded directly
+// into buf, only the part of a block not fitting into buf is kept for
+// subsequent calls.
+func (p *ParallelReader) Read(buf []byte) (int, error) {
+	if len(buf) == 0 {
+		return 0, nil
+	}
+
+	for len(p.cur) == 0 {
+		if p.err != nil {
+			return 0, p.err
+		}
+		n, err := p.next(buf)
+		if err != nil {
+			p.err = err
+			p.Close()
+			continue
+		}
+		if n > 0 {
+			return n, nil
+		}
+	}
+
+	n := copy(buf, p.cur)
+	p.cur = p.cur[n:]
+	return n, nil
+}
+
+// next decodes the next block into buf and keeps the data exceeding buf in
+// cur. It returns the number of bytes written to buf.
+func (p *ParallelReader) next(buf []byte) (int, error) {
+	out, ok := <-p.results
+	if !ok {
+		return 0, io.EOF
+	}
+	res := <-out
+	for {
+		if res.err != nil && res.job != nil {
+			res = p.merge(res)
+		}
+		if res.err != nil {
+			return 0, res.err
+		}
+
+		var n int
+		n, p.cur, res.err = res.output(buf)
+		if res.err == nil {
+			return n, nil
+		}
+		// blocks failing their checksum are merged with their successor, too
+		p.cur = nil
+	}
+}
+
+// output writes the data of a decoded block to buf and returns the number
+// of bytes written as well as the remaining data not fitting into buf
+func (res *result) output(buf []byte) (int, []byte, error) {
+	n := copy(buf, res.first)
+	for n < len(buf) {
+		m, err := res.bz.Read(buf[n:])
+		n += m
+		if err == io.EOF {
+			return n, nil, nil
+		} else if err != nil {
+			return n, nil, err
+		}
+	}
+
+	var rest bytes.Buffer
+	_, err := rest.ReadFrom(res.bz)
+	return n, rest.Bytes(), err
+}
+
+// Close stops all goroutines. It needs to be called if the data are not read
+// until the end of the stream.
+func (p *ParallelReader) Close() error {
+	p.once.Do(func() { close(p.done) })
+	return nil
+}
+
+// merge handles blocks which failed to decode. These are the result of
+// spurious block magics within compressed data and are merged with their
+// successor until decoding succeeds. Merging stops once the merged block
+// exceeds the maximum size of a bzip2 block since the data are corrupt then.
+func (p *ParallelReader) merge(res result) result {
+	for res.err != nil && res.job != nil {
+		out, ok := <-p.results
+		if !ok {
+			return res
+		}
+		next := <-out
+		if next.job == nil || next.job.bitStart != res.job.bitEnd ||
+			next.job.bitEnd-res.job.bitStart > maxBlockBits {
+			return res
+		}
+
+		j := &job{bitStart: res.job.bitStart, bitEnd: next.job.bitEnd}
+		j.raw = append(res.job.raw[:res.job.bitEnd/8-res.job.bitStart/8:res.job.bitEnd/8-
+			res.job.bitStart/8], next.job.raw...)
+		res = decodeJob(j)
+	}
+	return res
+}
+
+// decodeJobs decodes all jobs received on the provided channel. Once the
+// reader is closed the remaining jobs are skipped.
+func (p *ParallelReader) decodeJobs(jobs <-chan *job) {
+	for j := range jobs {
+		select {
+		case <-p.done:
+			continue
+		default:
+		}
+		j.out <- decodeJob(j)
+	}
+}
+
+// decodeJob decodes the block described by the job up to its first byte
+func decodeJob(j *job) result {
+	stream, err := assemble(j.raw, j.bitStart, j.bitEnd)
+	if err != nil {
+		return result{err: err, job: j}
+	}
+	bz := bzip2.NewReader(bytes.NewReader(stream))
+	first := make([]byte, 1)
+	n, err := bz.Read(first)
+	if err != nil && err != io.EOF {
+		return result{err: err, job: j}
+	}
+	return result{bz: bz, first: first[:n], job: j}
+}
+
+// scan reads the compressed stream, locates the candidate blocks and hands
+// them to the decoders in order
+func (p *ParallelReader) scan(r io.Reader, jobs chan<- *job) {
+	defer close(p.results)
+	defer close(jobs)
+
+	br := bufio.NewReaderSize(r, 1<<16)
+	var raw []byte // compressed bytes starting at byte rawStart
+	var rawStart int64
+	var reg uint64
+	var pos, start int64 // bits consumed so far, start of current block
+	open := false
+
+	// submit sends the block ending at bit end to the decoders
+	submit := func(end int64) bool {
+		first, last := start/8-rawStart, (end+7)/8-rawStart
+		j := &job{
+			raw:      append([]byte(nil), raw[first:last]...),
+			bitStart: start,
+			bitEnd:   end,
+			out:      make(chan result, 1),
+		}
+		select {
+		case p.results <- j.out:
+		case <-p.done:
+			return false
+		}
+		select {
+		case jobs <- j:
+		case <-p.done:
+			return false
+		}
+
+		// discard bytes preceding the end of the submitted block
+		keep := end/8 - rawStart
+		raw = raw[:copy(raw, raw[keep:])]
+		rawStart += keep
+		return true
+	}
+
+	// fail reports a fatal error to the reader
+	fail := func(err error) {
+		out := make(chan result, 1)
+		out <- result{err: err}
+		select {
+		case p.results <- out:
+		case <-p.done:
+		}
+	}
+
+	for {
+		c, err := br.ReadByte()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			fail(err)
+			return
+		}
+		raw = append(raw, c)
+
+		for i := 7; i >= 0; i-- {
+			reg = reg<<1 | uint64(c>>uint(i))&1
+			pos++
+			if pos < 48 {
+				continue
+			}
+
+			magic := reg & magicMask
+			if magic != blockMagic && magic != finalMagic {
+				continue
+			}
+			if open && !submit(pos-48) {
+				return
+			}
+			open = magic == blockMagic
+			start = pos - 48
+		}
+
+		// outside of blocks only keep enough bytes to hold the next block magic
+		if !open && len(raw) > maxIdleBytes {
+			keep := int64(len(raw)) - 8
+			raw = raw[:copy(raw, raw[keep:])]
+			rawStart += keep
+		}
+		if open && pos-start > maxBlockBits {
+			fail(fmt.Errorf("bzip2 block at bit %d exceeds the maximum block size",
+				start))
+			return
+		}
+	}
+
+	if open {
+		fail(io.ErrUnexpectedEOF)
+	}
+}
@@ -0,0 +1,92 @@
+package bzindex
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"testing"
+	"testing/iotest"
+)
+
+// testData returns the uncompressed content of the test files in testdata.
+// data.bz2 holds them as a single stream of five blocks (compressed via
+// bzip2 -1), multi.bz2 as two concatenated streams.
+func testData() []byte {
+	var buf bytes.Buffer
+	for i := 0; i < 100000; i++ {
+		fmt.Fprintf(&buf, "%d,%d\n", i%100, i%7)
+	}
+	return buf.Bytes()
+}
+
+// readTestFile returns the content of the named file in testdata
+func readTestFile(t *testing.T, name string) []byte {
+	content, err := ioutil.ReadFile("testdata/" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return content
+}
+
+func TestParallelReader(t *testing.T) {
+	want := testData()
+	reads := map[string]func(r io.Reader) ([]byte, error){
+		// a single buffer holding all data as used by the parser
+		"preallocated": func(r io.Reader) ([]byte, error) {
+			buf := bytes.NewBuffer(make([]byte, 0, len(want)+512))
+			_, err := buf.ReadFrom(r)
+			return buf.Bytes(), err
+		},
+		"small reads": func(r io.Reader) ([]byte, error) {
+			return ioutil.ReadAll(iotest.HalfReader(r))
+		},
+		"single bytes": func(r io.Reader) ([]byte, error) {
+			return ioutil.ReadAll(iotest.OneByteReader(r))
+		},
+	}
+
+	for _, file := range []string{"data.bz2", "multi.bz2"} {
+		content := readTestFile(t, file)
+		for _, threads := range []int{1, 2, 8} {
+			for name, read := range reads {
+				t.Run(fmt.Sprintf("%s/%d/%s", file, threads, name), func(t *testing.T) {
+					p := NewParallelReader(bytes.NewReader(content), threads)
+					defer p.Close()
+					got, err := read(p)
+					if err != nil {
+						t.Fatal(err)
+					}
+					if !bytes.Equal(got, want) {
+						t.Errorf("got %d bytes of decompressed data, want %d", len(got),
+							len(want))
+					}
+				})
+			}
+		}
+	}
+}
+
+func TestParallelReaderCorrupt(t *testing.T) {
+	content := readTestFile(t, "data.bz2")
+	corrupt := append([]byte(nil), content...)
+	corrupt[len(corrupt)/2] ^= 0x55
+
+	tests := map[string][]byte{
+		"corrupt block": corrupt,
+		"truncated":     content[:len(content)/2],
+	}
+	for name, data := range tests {
+		t.Run(name, func(t *testing.T) {
+			p := NewParallelReader(bytes.NewReader(data), 4)
+			defer p.Close()
+			if _, err := ioutil.ReadAll(p); err == nil {
+				t.Fatal("expected decompression error")
+			}
+			// errors are sticky
+			if _, err := p.Read(make([]byte, 1)); err == nil {
+				t.Error("expected error after failed read")
+			}
+		})
+	}
+}
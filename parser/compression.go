@@ -0,0 +1,196 @@
+package parser
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"io"
+	"os"
+	"runtime"
+	"sync"
+
+	"github.com/haskelladdict/mbdr/libmbd"
+	"github.com/haskelladdict/mbdr/parser/bzindex"
+)
+
+// Decompressor wraps a compressed io.Reader and returns an io.Reader
+// providing the decompressed data. If the returned io.Reader also implements
+// io.Closer it is closed once the parser is done with it.
+type Decompressor func(r io.Reader) (io.Reader, error)
+
+// compression describes a compression format via its name, the magic bytes
+// at the beginning of a compressed stream, and the matching decompressor.
+// A nil decompressor indicates a known format for which no decoder is
+// available (yet).
+type compression struct {
+	name   string
+	magic  []byte
+	decomp Decompressor
+}
+
+// list of known compression formats. Formats without a decoder in the
+// standard library can be made available via RegisterDecompressor
+var (
+	compressionsMu sync.RWMutex
+	compressions   = []compression{
+		{"gzip", []byte{0x1f, 0x8b}, func(r io.Reader) (io.Reader, error) {
+			return gzip.NewReader(r)
+		}},
+		{"bzip2", []byte("BZh"), ParallelBzip2(0)},
+		{"xz", []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}, nil},
+		{"zstd", []byte{0x28, 0xb5, 0x2f, 0xfd}, nil},
+	}
+)
+
+// ParallelBzip2 returns a Decompressor for bzip2 compressed data which
+// decodes the bzip2 blocks concurrently using the given number of goroutines.
+// If threads <= 0, GOMAXPROCS goroutines are used. This is the default
+// decompressor for bzip2 and considerably speeds up reading large files on
+// multi-core machines. Use RegisterDecompressor("bzip2", []byte("BZh"),
+// ParallelBzip2(1)) to restore purely sequential decompression.
+func ParallelBzip2(threads int) Decompressor {
+	return func(r io.Reader) (io.Reader, error) {
+		if threads == 1 || (threads <= 0 && runtime.GOMAXPROCS(0) == 1) {
+			return bzip2.NewReader(r), nil
+		}
+		return bzindex.NewParallelReader(r, threads), nil
+	}
+}
+
+// RegisterDecompressor registers the decompressor for the named compression
+// format whose streams start with the provided magic bytes. Registering an
+// already known format replaces its magic bytes and decompressor.
+func RegisterDecompressor(name string, magic []byte, d Decompressor) {
+	compressionsMu.Lock()
+	defer compressionsMu.Unlock()
+
+	c := compression{name, append([]byte(nil), magic...), d}
+	for i := range compressions {
+		if compressions[i].name == name {
+			compressions[i] = c
+			return
+		}
+	}
+	compressions = append(compressions, c)
+}
+
+// Compressions returns the names of all known compression formats and
+// whether a decompressor is available for each of them
+func Compressions() map[string]bool {
+	compressionsMu.RLock()
+	defer compressionsMu.RUnlock()
+
+	formats := map[string]bool{"none": true}
+	for _, c := range compressions {
+		formats[c.name] = c.decomp != nil
+	}
+	return formats
+}
+
+// Compression determines the compression format of the named data file based
+// on its magic bytes. Uncompressed binary data and MCell ASCII reaction data
+// output are reported as format "none".
+func Compression(filename string) (string, error) {
+	if isASCII(filename) {
+		return "none", nil
+	}
+	file, err := os.Open(filename)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	magic := make([]byte, sniffLen)
+	n, err := io.ReadFull(file, magic)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", err
+	}
+	if n == 0 {
+		return "", libmbd.WithFile(emptyError(), filename)
+	}
+
+	c, ok := findCompression(magic[:n])
+	if !ok {
+		return "", libmbd.WithFile(libmbd.NewError(libmbd.ErrUnknownCompression,
+			"unknown compression format"), filename)
+	}
+	return c.name, nil
+}
+
+// decompress determines the compression format of the provided stream based
+// on its magic bytes and returns a buffered reader for the decompressed data.
+// The returned io.Closer releases the resources held by the decompressor and
+// has to be called once the caller is done reading.
+func decompress(r io.Reader) (*bufio.Reader, io.Closer, error) {
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(sniffLen)
+	if err != nil && err != io.EOF {
+		return nil, nil, err
+	}
+	if len(magic) == 0 {
+		return nil, nil, emptyError()
+	}
+
+	c, ok := findCompression(magic)
+	if !ok {
+		return nil, nil, libmbd.NewError(libmbd.ErrUnknownCompression,
+			"unknown compression format")
+	}
+	if c.name == "none" {
+		return br, nopCloser{}, nil
+	}
+	if c.decomp == nil {
+		return nil, nil, libmbd.NewError(libmbd.ErrUnsupportedCompression,
+			"unsupported compression format %s", c.name)
+	}
+	file, err := c.decomp(br)
+	if err != nil {
+		return nil, nil, classify(err, "failed to decompress data")
+	}
+	closer, ok := file.(io.Closer)
+	if !ok {
+		closer = nopCloser{}
+	}
+	return bufio.NewReader(file), closer, nil
+}
+
+// nopCloser is an io.Closer which does nothing
+type nopCloser struct{}
+
+// Close implements io.Closer
+func (nopCloser) Close() error {
+	return nil
+}
+
+// findCompression returns a copy of the compression format matching the
+// provided magic bytes (see detectCompression). Since the copy is independent
+// of compressionsMu, its decompressor can be called without holding the lock
+// and thus may block on its input or register further decompressors.
+func findCompression(magic []byte) (compression, bool) {
+	compressionsMu.RLock()
+	defer compressionsMu.RUnlock()
+
+	c := detectCompression(magic)
+	if c == nil {
+		return compression{}, false
+	}
+	return *c, true
+}
+
+// detectCompression returns the compression format matching the provided
+// magic bytes or nil if the format is unknown. Uncompressed data, i.e. data
+// recognized by one of the registered data formats, are reported as format
+// "none".
+// NOTE: The caller is expected to hold compressionsMu
+func detectCompression(magic []byte) *compression {
+	if detectFormat(magic) != nil {
+		return &compression{name: "none"}
+	}
+	for i := range compressions {
+		if bytes.HasPrefix(magic, compressions[i].magic) {
+			return &compressions[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+package parser
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/haskelladdict/mbdr/libmbd"
+)
+
+// TestDecompressorUnlocked checks that decompressors are called without
+// holding the lock of the registered compression formats so that they can
+// register decompressors themselves
+func TestDecompressorUnlocked(t *testing.T) {
+	magic := []byte("MBDRTEST")
+	var register Decompressor
+	register = func(r io.Reader) (io.Reader, error) {
+		RegisterDecompressor("mbdrtest", magic, register)
+		prefix := make([]byte, len(magic))
+		if _, err := io.ReadFull(r, prefix); err != nil {
+			return nil, err
+		}
+		return r, nil
+	}
+	RegisterDecompressor("mbdrtest", magic, register)
+
+	step := libmbd.TimeSpec{OutputListType: libmbd.Step, StepSize: 1e-6}
+	content := append(append([]byte(nil), magic...), writeAPI2(t, api2Fixture(10),
+		step, 4)...)
+
+	done := make(chan error, 1)
+	go func() {
+		data, err := ReadFrom(bytes.NewReader(content))
+		if err == nil {
+			data.Close()
+		}
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("decompressor deadlocked")
+	}
+}
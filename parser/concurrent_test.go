@@ -0,0 +1,206 @@
+package parser
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/haskelladdict/mbdr/libmbd"
+)
+
+// numGoroutines is the number of goroutines used by the concurrency tests.
+// Run them via go test -race.
+const numGoroutines = 8
+
+// countsFixture returns the data blocks stored (with 1000 rows per stream
+// block and a STEP size of 1e-6) in testdata/counts.bin.bz2 which was
+// compressed via bzip2 -1 and consists of several bzip2 blocks
+func countsFixture() []libmbd.DataBlock {
+	col := func(m int, f float64) []float64 {
+		c := make([]float64, 20000)
+		for i := range c {
+			c[i] = float64(i%m) * f
+		}
+		return c
+	}
+	return []libmbd.DataBlock{
+		{Name: "A", Data: &libmbd.CountData{Col: [][]float64{col(13, 1)},
+			DataTypes: []uint16{libmbd.IntType}}},
+		{Name: "B", Data: &libmbd.CountData{Col: [][]float64{col(5, 0.5), col(3, 1)},
+			DataTypes: []uint16{libmbd.DoubleType, libmbd.IntType}}},
+		{Name: "C", Data: &libmbd.CountData{Col: [][]float64{col(11, 1)},
+			DataTypes: []uint16{libmbd.IntType}}},
+	}
+}
+
+// countsFiles writes the counts fixture uncompressed and bzip2 compressed to
+// dir and returns the names of both files
+func countsFiles(t *testing.T, dir string) (string, string) {
+	plain := filepath.Join(dir, "counts.bin")
+	step := libmbd.TimeSpec{OutputListType: libmbd.Step, StepSize: 1e-6}
+	if err := ioutil.WriteFile(plain, writeAPI2(t, countsFixture(), step, 1000),
+		0644); err != nil {
+		t.Fatal(err)
+	}
+
+	compressed := filepath.Join(dir, "counts.bin.bz2")
+	content, err := ioutil.ReadFile("testdata/counts.bin.bz2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(compressed, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return plain, compressed
+}
+
+// checkCounts compares the output times and data blocks of d with the counts
+// fixture. It accesses d via OutputTimes, BlockDataByName, BlockDataByIDRows,
+// and Rows.
+func checkCounts(d *libmbd.MCellData, want []libmbd.DataBlock) error {
+	times := d.OutputTimes()
+	if len(times) != 20000 || times[0] != 0 || times[19999] != 19999*1e-6 {
+		return fmt.Errorf("got %d output times, want 20000", len(times))
+	}
+
+	var ids []uint64
+	var cols [][]float64
+	for id, b := range want {
+		got, err := d.BlockDataByName(b.Name)
+		if err != nil {
+			return err
+		}
+		if !reflect.DeepEqual(got.Col, b.Data.Col) ||
+			!reflect.DeepEqual(got.DataTypes, b.Data.DataTypes) {
+			return fmt.Errorf("data block %s differs", b.Name)
+		}
+
+		// rows crossing stream block boundaries
+		part, err := d.BlockDataByIDRows(uint64(id), 990, 3010, 7)
+		if err != nil {
+			return err
+		}
+		for r, v := range part.Col[0] {
+			if v != b.Data.Col[0][990+7*r] {
+				return fmt.Errorf("row %d of data block %s differs", 990+7*r, b.Name)
+			}
+		}
+
+		ids = append(ids, uint64(id))
+		cols = append(cols, b.Data.Col...)
+	}
+
+	it, err := d.Rows(ids...)
+	if err != nil {
+		return err
+	}
+	r := 0
+	for ; it.Next(); r++ {
+		if it.Time() != times[r] {
+			return fmt.Errorf("output time of row %d differs", r)
+		}
+		for c, v := range it.Values() {
+			if v != cols[c][r] {
+				return fmt.Errorf("value %d of row %d differs", c, r)
+			}
+		}
+	}
+	if err := it.Err(); err != nil {
+		return err
+	}
+	if r != 20000 {
+		return fmt.Errorf("iterated over %d instead of 20000 rows", r)
+	}
+	return nil
+}
+
+// TestConcurrentRead reads the same files from many goroutines at once via
+// all readers including the parallel bzip2 decompressor
+func TestConcurrentRead(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mbdr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	plain, compressed := countsFiles(t, dir)
+	content, err := ioutil.ReadFile(compressed)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	readers := map[string]func() (*libmbd.MCellData, error){
+		"Read":           func() (*libmbd.MCellData, error) { return Read(plain) },
+		"Read bzip2":     func() (*libmbd.MCellData, error) { return Read(compressed) },
+		"ReadLazy":       func() (*libmbd.MCellData, error) { return ReadLazy(plain) },
+		"ReadLazy bzip2": func() (*libmbd.MCellData, error) { return ReadLazy(compressed) },
+		"ReadFrom bzip2": func() (*libmbd.MCellData, error) {
+			return ReadFrom(bytes.NewReader(content))
+		},
+	}
+
+	want := countsFixture()
+	var wg sync.WaitGroup
+	for name, read := range readers {
+		for i := 0; i < numGoroutines; i++ {
+			wg.Add(1)
+			go func(name string, read func() (*libmbd.MCellData, error)) {
+				defer wg.Done()
+				data, err := read()
+				if err != nil {
+					t.Errorf("%s: %s", name, err)
+					return
+				}
+				defer data.Close()
+				if err := checkCounts(data, want); err != nil {
+					t.Errorf("%s: %s", name, err)
+				}
+			}(name, read)
+		}
+	}
+	wg.Wait()
+}
+
+// TestSharedData accesses a single MCellData from many goroutines at once
+func TestSharedData(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mbdr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	plain, compressed := countsFiles(t, dir)
+
+	readers := map[string]func() (*libmbd.MCellData, error){
+		"Read":           func() (*libmbd.MCellData, error) { return Read(plain) },
+		"Read bzip2":     func() (*libmbd.MCellData, error) { return Read(compressed) },
+		"ReadLazy":       func() (*libmbd.MCellData, error) { return ReadLazy(plain) },
+		"ReadLazy bzip2": func() (*libmbd.MCellData, error) { return ReadLazy(compressed) },
+	}
+
+	want := countsFixture()
+	for name, read := range readers {
+		t.Run(name, func(t *testing.T) {
+			data, err := read()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer data.Close()
+
+			var wg sync.WaitGroup
+			for i := 0; i < numGoroutines; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					if err := checkCounts(data, want); err != nil {
+						t.Error(err)
+					}
+				}()
+			}
+			wg.Wait()
+		})
+	}
+}
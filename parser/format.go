@@ -0,0 +1,149 @@
+package parser
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"sync"
+
+	"github.com/haskelladdict/mbdr/libmbd"
+	"github.com/haskelladdict/mbdr/parser/parseAPI1"
+	"github.com/haskelladdict/mbdr/parser/parseAPI2"
+)
+
+// sniffLen is the number of leading bytes of the decompressed data which are
+// available for detecting the data format
+const sniffLen = 64
+
+// ParseFunc parses part of the decompressed binary data provided by the
+// io.Reader into RawData
+type ParseFunc func(r io.Reader, data *libmbd.RawData) (*libmbd.RawData, error)
+
+// Format describes a binary data format understood by the parser. A format
+// is recognized either via its Tag, which is consumed before Header is
+// called, or via Sniff which is passed the leading bytes of the data without
+// consuming them. Header parses the metadata and Data the remaining count
+// data of the file. Before calling Header, the API field of RawData is set to
+// the format's Name.
+// The optional Selected function reads only the count data of the data blocks
+// for which keep returns true and adjusts the metadata accordingly. Formats
+// without it are read completely by ReadSelected and filtered afterwards.
+// NOTE: libmbd decodes data blocks according to RawData's API field.
+// Formats whose data are not laid out according to one of libmbd's known API
+// versions therefore need to set it accordingly within Header.
+type Format struct {
+	Name     string
+	Tag      string
+	Sniff    func(magic []byte) bool
+	Header   ParseFunc
+	Data     ParseFunc
+	Selected func(r io.Reader, data *libmbd.RawData,
+		keep func(name string) bool) (*libmbd.RawData, error)
+}
+
+// list of registered formats
+var (
+	formatsMu sync.RWMutex
+	formats   []Format
+)
+
+func init() {
+	RegisterFormat(Format{Name: libmbd.API1, Tag: libmbd.API1, Header: parseAPI1.Header,
+		Data: parseAPI1.Data, Selected: parseAPI1.Selected})
+	RegisterFormat(Format{Name: libmbd.API2, Tag: libmbd.API2, Header: parseAPI2.Header,
+		Data: parseAPI2.Data, Selected: parseAPI2.Selected})
+}
+
+// RegisterFormat registers a data format with the parser. Registering a
+// format with the name of an already registered one replaces the latter.
+func RegisterFormat(f Format) {
+	formatsMu.Lock()
+	defer formatsMu.Unlock()
+
+	for i := range formats {
+		if formats[i].Name == f.Name {
+			formats[i] = f
+			return
+		}
+	}
+	formats = append(formats, f)
+}
+
+// Formats returns the names of all registered data formats in order of
+// registration
+func Formats() []string {
+	formatsMu.RLock()
+	defer formatsMu.RUnlock()
+
+	var names []string
+	for _, f := range formats {
+		names = append(names, f.Name)
+	}
+	return names
+}
+
+// detectFormat returns the registered format matching the provided leading
+// bytes of the decompressed data or nil if none matches
+func detectFormat(magic []byte) *Format {
+	formatsMu.RLock()
+	defer formatsMu.RUnlock()
+
+	for i, f := range formats {
+		if (f.Tag != "" && bytes.HasPrefix(magic, []byte(f.Tag))) ||
+			(f.Sniff != nil && f.Sniff(magic)) {
+			return &formats[i]
+		}
+	}
+	return nil
+}
+
+// rawFormat tests if the count data of the given format follow the header
+// verbatim as is the case for the builtin formats. Only these can be accessed
+// via memory mapping or random access.
+func rawFormat(f *Format) bool {
+	return f.Name == libmbd.API1 || f.Name == libmbd.API2
+}
+
+// peekReader is an io.Reader which allows looking at upcoming bytes without
+// consuming them
+type peekReader interface {
+	io.Reader
+	Peek(n int) ([]byte, error)
+}
+
+// parseHeader detects the format of the decompressed binary mcell data
+// provided by the io.Reader and parses its header. The returned format can be
+// used to parse the remaining count data.
+func parseHeader(r io.Reader) (*libmbd.RawData, *Format, error) {
+	br, ok := r.(peekReader)
+	if !ok {
+		br = bufio.NewReader(r)
+	}
+
+	magic, err := br.Peek(sniffLen)
+	if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
+		return nil, nil, err
+	}
+	if len(magic) == 0 {
+		return nil, nil, emptyError()
+	}
+
+	f := detectFormat(magic)
+	if f == nil {
+		if len(magic) > len(libmbd.API2) {
+			magic = magic[:len(libmbd.API2)]
+		}
+		return nil, nil, libmbd.NewError(libmbd.ErrUnknownAPI,
+			"unknown mcell binary api version %s", magic)
+	}
+	if _, err := io.ReadFull(br, make([]byte, len(f.Tag))); err != nil {
+		return nil, nil, emptyError()
+	}
+
+	data := new(libmbd.RawData)
+	data.API = f.Name
+	if data, err = f.Header(br, data); err != nil {
+		return nil, nil, classify(err, "failed to parse header")
+	}
+	return data, f, nil
+}
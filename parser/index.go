@@ -0,0 +1,195 @@
+package parser
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+
+	"github.com/haskelladdict/mbdr/libmbd"
+)
+
+// IndexSuffix is the file extension of header index sidecar files
+const IndexSuffix = ".mbdidx"
+
+// indexVersion is the version of the sidecar format. Sidecars of a different
+// version are ignored.
+const indexVersion = 2
+
+// smallFileLen is the size up to which the content hash stored in a sidecar
+// covers the whole data file
+const smallFileLen = 1 << 20
+
+// headerIndex is the content of a sidecar file. Size, ModTime, and Hash
+// describe the data file at the time the index was created and are used to
+// detect stale sidecars. Hash covers the leading HashLen bytes of the data
+// file which include the complete (possibly compressed) header.
+type headerIndex struct {
+	Version        int
+	Size           int64
+	ModTime        int64
+	HashLen        int64
+	Hash           string
+	API            string
+	OutputListType uint16
+	BlockSize      uint64
+	StepSize       float64
+	TimeList       []float64
+	BlockNames     []string
+	libmbd.API1Data
+	libmbd.API2Data
+}
+
+// IndexName returns the name of the sidecar file for the named data file
+func IndexName(filename string) string {
+	return filename + IndexSuffix
+}
+
+// WriteIndex parses the header of the named binary mcell data file and stores
+// it in the sidecar file next to it. ReadHeader uses the sidecar instead of
+// parsing the data file as long as the latter has not changed.
+func WriteIndex(filename string) error {
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	counter := &sourceCounter{r: file}
+	data, err := readHeaderFrom(counter)
+	if err != nil {
+		return libmbd.WithFile(err, filename)
+	}
+
+	// the bytes read while parsing the header are an upper bound of the
+	// header's extent within the (compressed) data file
+	hashLen := atomic.LoadInt64(&counter.n)
+	if info.Size() <= smallFileLen {
+		hashLen = info.Size()
+	}
+	// NOTE: decompressors may still read from file in the background, hence
+	// the hashed bytes are accessed via ReadAt
+	hash, err := contentHash(io.NewSectionReader(file, 0, hashLen), hashLen)
+	if err != nil {
+		return err
+	}
+
+	idx := headerIndex{
+		Version:        indexVersion,
+		Size:           info.Size(),
+		ModTime:        info.ModTime().UnixNano(),
+		HashLen:        hashLen,
+		Hash:           hash,
+		API:            data.API,
+		OutputListType: data.OutputListType,
+		BlockSize:      data.BlockSize,
+		StepSize:       data.StepSize,
+		TimeList:       data.TimeList,
+		BlockNames:     data.BlockNames,
+		API1Data:       data.API1Data,
+		API2Data:       data.API2Data,
+	}
+	content, err := json.Marshal(&idx)
+	if err != nil {
+		return err
+	}
+
+	// write to a temporary file first so readers never see a partial sidecar
+	tmp, err := ioutil.TempFile(filepath.Dir(filename), filepath.Base(filename)+".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), IndexName(filename))
+}
+
+// IndexCurrent tests if the named data file has a sidecar which is up to date
+func IndexCurrent(filename string) bool {
+	_, ok := readIndex(filename)
+	return ok
+}
+
+// readIndex returns the header of the named data file stored in its sidecar.
+// If there is no sidecar or it is stale or unreadable, ok is false.
+func readIndex(filename string) (data *libmbd.RawData, ok bool) {
+	content, err := ioutil.ReadFile(IndexName(filename))
+	if err != nil {
+		return nil, false
+	}
+	var idx headerIndex
+	if err := json.Unmarshal(content, &idx); err != nil || idx.Version != indexVersion {
+		return nil, false
+	}
+
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, false
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil || info.Size() != idx.Size || info.ModTime().UnixNano() != idx.ModTime {
+		return nil, false
+	}
+	if hash, err := contentHash(file, idx.HashLen); err != nil || hash != idx.Hash {
+		return nil, false
+	}
+
+	data = &libmbd.RawData{
+		OutputListType: idx.OutputListType,
+		BlockSize:      idx.BlockSize,
+		StepSize:       idx.StepSize,
+		TimeList:       idx.TimeList,
+		NumBlocks:      uint64(len(idx.BlockNames)),
+		BlockNames:     idx.BlockNames,
+		BlockNameMap:   make(map[string]uint64),
+		API:            idx.API,
+		FileName:       filename,
+		API1Data:       idx.API1Data,
+		API2Data:       idx.API2Data,
+	}
+	for i, n := range idx.BlockNames {
+		data.BlockNameMap[n] = uint64(i)
+	}
+	return data, true
+}
+
+// contentHash returns the hex encoded SHA-256 hash of the leading n bytes of
+// the provided file
+func contentHash(r io.Reader, n int64) (string, error) {
+	h := sha256.New()
+	if _, err := io.CopyN(h, r, n); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// sourceCounter counts the bytes read from the underlying io.Reader. Unlike
+// countingReader it is meant to wrap compressed data and since decompressors
+// may read concurrently the count is updated atomically.
+type sourceCounter struct {
+	r io.Reader
+	n int64
+}
+
+// Read implements io.Reader
+func (c *sourceCounter) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	atomic.AddInt64(&c.n, int64(n))
+	return n, err
+}
@@ -0,0 +1,89 @@
+package parser
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/haskelladdict/mbdr/libmbd"
+)
+
+// TestStaleIndex checks that ReadHeader ignores sidecars of data files whose
+// header changed without changing their size and modification time
+func TestStaleIndex(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mbdr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	step := libmbd.TimeSpec{OutputListType: libmbd.Step, StepSize: 1e-6}
+	newBlocks := func(numBlocks, numRows int) []libmbd.DataBlock {
+		blocks := make([]libmbd.DataBlock, numBlocks)
+		for i := range blocks {
+			blocks[i] = libmbd.DataBlock{Name: fmt.Sprintf("block_%05d_of_a_large_header", i),
+				Data: &libmbd.CountData{Col: [][]float64{seq(numRows, float64(i), 1)},
+					DataTypes: []uint16{libmbd.IntType}}}
+		}
+		return blocks
+	}
+
+	// the header of the large file extends far beyond its first 64 KiB
+	tests := []struct {
+		name      string
+		numBlocks int
+		numRows   int
+	}{
+		{"small", 10, 10},
+		{"large", 4000, 40},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filename := filepath.Join(dir, tt.name+".bin")
+			content := writeAPI2(t, newBlocks(tt.numBlocks, tt.numRows), step, 1000)
+			if tt.name == "large" && len(content) <= smallFileLen {
+				t.Fatalf("large file has only %d bytes", len(content))
+			}
+			if err := ioutil.WriteFile(filename, content, 0644); err != nil {
+				t.Fatal(err)
+			}
+			if err := WriteIndex(filename); err != nil {
+				t.Fatal(err)
+			}
+			if !IndexCurrent(filename) {
+				t.Fatal("new sidecar is stale")
+			}
+
+			// rename the last data block in place and restore the modification time
+			info, err := os.Stat(filename)
+			if err != nil {
+				t.Fatal(err)
+			}
+			last := fmt.Sprintf("block_%05d_of_a_large_header", tt.numBlocks-1)
+			renamed := fmt.Sprintf("block_%05d_of_a_LARGE_header", tt.numBlocks-1)
+			i := bytes.LastIndex(content, []byte(last))
+			copy(content[i:], renamed)
+			if err := ioutil.WriteFile(filename, content, 0644); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Chtimes(filename, info.ModTime(), info.ModTime()); err != nil {
+				t.Fatal(err)
+			}
+
+			if IndexCurrent(filename) {
+				t.Error("stale sidecar is current")
+			}
+			data, err := ReadHeader(filename)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer data.Close()
+			if _, err := data.BlockNameToID(renamed); err != nil {
+				t.Errorf("header was read from stale sidecar: %s", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,72 @@
+package parser
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/haskelladdict/mbdr/libmbd"
+)
+
+// Inspect opens the binary mcell data file, parses the header, and determines
+// the length of the count data section without keeping the data in memory.
+// Together with MCellData.Validate this allows checking the structure of
+// even very large data files.
+// NOTE: MCell ASCII reaction data output is read completely via ReadASCII.
+func Inspect(filename string) (*libmbd.MCellData, uint64, error) {
+	if isASCII(filename) {
+		raw, err := readASCII([]string{filename})
+		if err != nil {
+			return nil, 0, err
+		}
+		return libmbd.FromRaw(raw), uint64(len(raw.Buffer)), nil
+	}
+
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer file.Close()
+
+	raw, dataLen, err := inspectFrom(file)
+	if err != nil {
+		err = libmbd.WithFile(err, filename)
+	}
+	if raw == nil {
+		return nil, dataLen, err
+	}
+	raw.FileName = filename
+	return libmbd.FromRaw(raw), dataLen, err
+}
+
+// InspectFrom parses the header of the binary mcell data provided by the
+// io.Reader and determines the length of the count data section. See Inspect
+// for details.
+func InspectFrom(r io.Reader) (*libmbd.MCellData, uint64, error) {
+	raw, dataLen, err := inspectFrom(r)
+	if raw == nil {
+		return nil, dataLen, err
+	}
+	return libmbd.FromRaw(raw), dataLen, err
+}
+
+// inspectFrom parses the header of the binary mcell data provided by the
+// io.Reader and determines the length of the count data section
+func inspectFrom(r io.Reader) (*libmbd.RawData, uint64, error) {
+	file, closer, err := decompress(r)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer closer.Close()
+
+	data, _, err := parseHeader(file)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	dataLen, err := io.Copy(ioutil.Discard, file)
+	if err != nil {
+		return data, uint64(dataLen), classify(err, "failed to read count data")
+	}
+	return data, uint64(dataLen), nil
+}
@@ -0,0 +1,130 @@
+package parser
+
+import (
+	"bufio"
+	"io"
+	"os"
+
+	"github.com/haskelladdict/mbdr/libmbd"
+	"github.com/haskelladdict/mbdr/parser/bzindex"
+)
+
+// ReadLazy opens the binary mcell data file and parses the header. In contrast
+// to Read the count data are not read into memory. Instead, only the byte
+// ranges required for a requested data block are decoded on demand which keeps
+// memory use small and fixed even for multi GB data files. This requires
+// either an uncompressed or a bzip2 compressed file. For the latter, the file
+// is indexed once to determine the bzip2 block boundaries.
+// NOTE: The returned MCellData keeps the file open until its Close method is
+// called.
+// NOTE: MCell ASCII reaction data output is always read completely via
+// ReadASCII.
+func ReadLazy(filename string) (*libmbd.MCellData, error) {
+	if isASCII(filename) {
+		return ReadASCII(filename)
+	}
+
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	raw, err := readLazy(file)
+	if err != nil {
+		file.Close()
+		return nil, libmbd.WithFile(err, filename)
+	}
+	raw.FileName = filename
+	return libmbd.FromRaw(raw), nil
+}
+
+// readLazy parses the header of the provided file and sets up the data source
+// for on demand access to the count data
+func readLazy(file *os.File) (*libmbd.RawData, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	src, size, err := randomAccess(file, info.Size())
+	if err != nil {
+		return nil, err
+	}
+
+	r := &countingReader{r: bufio.NewReader(io.NewSectionReader(src, 0, size))}
+	data, f, err := parseHeader(r)
+	if err != nil {
+		return nil, err
+	}
+	if !rawFormat(f) {
+		return nil, libmbd.NewError(libmbd.ErrUnknownAPI,
+			"random access is not supported for %s data", f.Name)
+	}
+	data.Source = lazySource{io.NewSectionReader(src, r.n, size-r.n), file}
+	return data, nil
+}
+
+// randomAccess returns an io.ReaderAt providing random access to the
+// decompressed content of file as well as the size of the decompressed data
+func randomAccess(file *os.File, size int64) (io.ReaderAt, int64, error) {
+	magic := make([]byte, sniffLen)
+	n, err := file.ReadAt(magic, 0)
+	if err != nil && err != io.EOF {
+		return nil, 0, err
+	}
+	if n == 0 {
+		return nil, 0, emptyError()
+	}
+	c, ok := findCompression(magic[:n])
+
+	switch {
+	case !ok:
+		return nil, 0, libmbd.NewError(libmbd.ErrUnknownCompression,
+			"unknown compression format")
+
+	case c.name == "none":
+		return file, size, nil
+
+	case c.name == "bzip2":
+		idx, err := bzindex.Build(file, size)
+		if err != nil {
+			e := libmbd.NewError(libmbd.ErrCorrupt, "failed to index bzip2 blocks")
+			e.Err = err
+			return nil, 0, e
+		}
+		return bzindex.NewReader(file, idx), idx.Size, nil
+	}
+	return nil, 0, libmbd.NewError(libmbd.ErrUnsupportedCompression,
+		"random access is not supported for %s compressed data", c.name)
+}
+
+// lazySource provides random access to the count data section of a binary
+// mcell data file and closes the underlying file once done
+type lazySource struct {
+	*io.SectionReader
+	file *os.File
+}
+
+// Close closes the underlying file
+func (s lazySource) Close() error {
+	return s.file.Close()
+}
+
+// countingReader keeps track of the number of bytes read from the
+// underlying bufio.Reader
+type countingReader struct {
+	r *bufio.Reader
+	n int64
+}
+
+// Read implements io.Reader
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// Peek returns the next n bytes without advancing the reader
+func (c *countingReader) Peek(n int) ([]byte, error) {
+	return c.r.Peek(n)
+}
@@ -0,0 +1,146 @@
+package parser
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/haskelladdict/mbdr/libmbd"
+)
+
+// MetaSuffix is the file extension of per file metadata sidecars. Metadata
+// for all data files within a directory can be provided via a DirMetaName
+// file.
+const (
+	MetaSuffix  = ".mbdmeta"
+	DirMetaName = "mbdr" + MetaSuffix
+)
+
+// mdlSuffix is the file extension of MCell model description files
+const mdlSuffix = ".mdl"
+
+// ReadMeta reads the metadata of data blocks from the named file. MCell
+// model description files (.mdl) are parsed via ParseMDL, all other files are
+// expected to be metadata sidecars as written by WriteMeta, i.e. JSON objects
+// mapping data block names (or regular expressions matching them) to
+// libmbd.BlockMeta.
+func ReadMeta(filename string) (map[string]libmbd.BlockMeta, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	if strings.HasSuffix(filename, mdlSuffix) {
+		return ParseMDL(file)
+	}
+
+	var meta map[string]libmbd.BlockMeta
+	if err := json.NewDecoder(file).Decode(&meta); err != nil {
+		e := libmbd.NewError(libmbd.ErrCorrupt, "failed to parse metadata")
+		e.File, e.Err = filename, err
+		return nil, e
+	}
+	return meta, nil
+}
+
+// WriteMeta writes the provided metadata of data blocks to the named
+// metadata sidecar. See ReadMeta for the format.
+func WriteMeta(filename string, meta map[string]libmbd.BlockMeta) error {
+	content, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filename, append(content, '\n'), 0644)
+}
+
+// mdlOutput matches a single count statement of a REACTION_DATA_OUTPUT block,
+// e.g. {COUNT[ca, WORLD], COUNT[b, WORLD] : "b"} => "./react_data/ca.dat"
+var mdlOutput = regexp.MustCompile(`\{([^{}]*)\}\s*=>\s*"([^"]*)"`)
+
+// mdlLabel matches the optional custom header of a count expression
+var mdlLabel = regexp.MustCompile(`:\s*"([^"]*)"\s*$`)
+
+// ParseMDL extracts the count expressions of all REACTION_DATA_OUTPUT
+// statements from the provided MCell model description and returns them as
+// column labels keyed by the name of the corresponding output file. Custom
+// column headers (<expression> : "header") are used as labels if present.
+// Since MCell names data blocks either after the output file or its base
+// name, both are used as keys.
+func ParseMDL(r io.Reader) (map[string]libmbd.BlockMeta, error) {
+	content, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	meta := make(map[string]libmbd.BlockMeta)
+	for _, m := range mdlOutput.FindAllStringSubmatch(string(content), -1) {
+		var labels []string
+		for _, expr := range splitExpressions(m[1]) {
+			if l := mdlLabel.FindStringSubmatch(expr); l != nil {
+				labels = append(labels, l[1])
+			} else {
+				labels = append(labels, strings.Join(strings.Fields(expr), " "))
+			}
+		}
+
+		info := map[string]string{"output": m[2]}
+		block := libmbd.BlockMeta{Labels: labels, Info: info}
+		meta[m[2]] = block
+		meta[filepath.Base(m[2])] = block
+	}
+	return meta, nil
+}
+
+// splitExpressions splits a comma separated list of count expressions while
+// ignoring commas within brackets and quotes
+func splitExpressions(list string) []string {
+	var exprs []string
+	depth, start, quoted := 0, 0, false
+	for i, c := range list {
+		switch {
+		case c == '"':
+			quoted = !quoted
+		case quoted:
+		case c == '[' || c == '(':
+			depth++
+		case c == ']' || c == ')':
+			depth--
+		case c == ',' && depth == 0:
+			exprs = append(exprs, strings.TrimSpace(list[start:i]))
+			start = i + 1
+		}
+	}
+	if last := strings.TrimSpace(list[start:]); last != "" {
+		exprs = append(exprs, last)
+	}
+	return exprs
+}
+
+// LoadMeta attaches the metadata provided via the sidecar of the named data
+// file or, if there is none, via the metadata file of its directory (see
+// ReadMeta). The readers of this package don't load metadata by themselves so
+// that a malformed or mismatched sidecar never renders valid data unreadable.
+// In case of an error the metadata of data remain unchanged.
+func LoadMeta(data *libmbd.MCellData, filename string) error {
+	dir := filepath.Dir(filename)
+	if info, err := os.Stat(filename); err == nil && info.IsDir() {
+		dir = filename
+	}
+
+	for _, n := range []string{filename + MetaSuffix, filepath.Join(dir, DirMetaName)} {
+		if _, err := os.Stat(n); err != nil {
+			continue
+		}
+		meta, err := ReadMeta(n)
+		if err != nil {
+			return err
+		}
+		return data.SetMeta(meta)
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+package parser
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/haskelladdict/mbdr/libmbd"
+)
+
+// TestLoadMeta checks that data with a malformed or mismatched sidecar remain
+// readable and that LoadMeta leaves their metadata unchanged on failure
+func TestLoadMeta(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mbdr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "data.bin")
+	step := libmbd.TimeSpec{OutputListType: libmbd.Step, StepSize: 1e-6}
+	if err := ioutil.WriteFile(filename, writeAPI2(t, api2Fixture(5), step, 5),
+		0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		sidecar string
+		label   string // label of the first column of data block mixed
+		fail    bool
+	}{
+		{"valid", `{"mix.*": {"labels": ["a", "b", "c"]}}`, "a", false},
+		{"malformed", `{"mixed": {"labels": [`, "", true},
+		{"mismatched", `{"mixed": {"labels": ["a"]}, "bound_A": {"labels": ["x"]}}`, "",
+			true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := ioutil.WriteFile(filename+MetaSuffix, []byte(tt.sidecar),
+				0644); err != nil {
+				t.Fatal(err)
+			}
+			data, err := Read(filename)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer data.Close()
+			for id := uint64(0); id < data.NumDataBlocks(); id++ {
+				if !data.BlockMetaByID(id).Empty() {
+					t.Errorf("metadata were loaded by Read")
+				}
+			}
+
+			err = LoadMeta(data, filename)
+			if (err != nil) != tt.fail {
+				t.Errorf("got error %v, expected failure: %t", err, tt.fail)
+			}
+			id, _ := data.BlockNameToID("mixed")
+			if l := data.BlockMetaByID(id).Label(0); l != tt.label {
+				t.Errorf("got label %q, want %q", l, tt.label)
+			}
+			id, _ = data.BlockNameToID("bound_A")
+			if tt.fail && !data.BlockMetaByID(id).Empty() {
+				t.Errorf("metadata were changed despite failure")
+			}
+		})
+	}
+}
@@ -0,0 +1,65 @@
+package parser
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"os"
+
+	"github.com/haskelladdict/mbdr/libmbd"
+	"github.com/haskelladdict/mbdr/parser/util"
+)
+
+// readMapped parses the header of an uncompressed binary mcell data file and
+// memory maps the count data instead of reading them into memory. This way
+// the data are held by the OS page cache and can be shared among all
+// processes and threads working on the same file. The returned bool is false
+// if the file is compressed or can not be mapped on this platform, in which
+// case the caller should fall back to reading the data.
+func readMapped(file *os.File) (*libmbd.RawData, bool, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return nil, false, err
+	}
+
+	magic := make([]byte, sniffLen)
+	n, err := file.ReadAt(magic, 0)
+	if (err != nil && err != io.EOF) || detectFormat(magic[:n]) == nil {
+		return nil, false, nil
+	}
+
+	buf, err := util.Mmap(file, info.Size())
+	if err == util.ErrMmapUnsupported {
+		return nil, false, nil
+	} else if err != nil {
+		return nil, false, err
+	}
+
+	r := &countingReader{r: bufio.NewReader(bytes.NewReader(buf))}
+	data, f, err := parseHeader(r)
+	if err != nil {
+		util.Munmap(buf)
+		return nil, true, err
+	}
+	// only the builtin formats store their count data verbatim
+	if !rawFormat(f) {
+		util.Munmap(buf)
+		return nil, false, nil
+	}
+	data.Buffer = buf[r.n:]
+	data.Source = mappedSource{bytes.NewReader(data.Buffer), buf}
+	return data, true, nil
+}
+
+// mappedSource provides access to memory mapped count data and releases the
+// mapping once done
+type mappedSource struct {
+	io.ReaderAt
+	mapping util.ReadBuf
+}
+
+// Close unmaps the underlying memory mapping. Any count data buffer backed by
+// it must no longer be accessed afterwards.
+func (s mappedSource) Close() error {
+	return util.Munmap(s.mapping)
+}
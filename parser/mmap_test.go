@@ -0,0 +1,97 @@
+package parser
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/haskelladdict/mbdr/libmbd"
+)
+
+// TestReadMapped compares memory mapped with buffered data of uncompressed
+// API1 and API2 files and checks that Close releases the mapping
+func TestReadMapped(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("memory mapping is only used on Linux")
+	}
+	dir, err := ioutil.TempDir("", "mbdr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	step := libmbd.TimeSpec{OutputListType: libmbd.Step, StepSize: 1e-6}
+	files := map[string][]byte{
+		"api1.bin": writeAPI1([]api1Block{{"ints", 0, seq(7, 3, 2)},
+			{"doubles", 1, seq(7, 0.1, 0.5)}}, step, 7),
+		"api2.bin": writeAPI2(t, api2Fixture(25), step, 10),
+	}
+	for name, content := range files {
+		t.Run(name, func(t *testing.T) {
+			filename := filepath.Join(dir, name)
+			if err := ioutil.WriteFile(filename, content, 0644); err != nil {
+				t.Fatal(err)
+			}
+			file, err := os.Open(filename)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer file.Close()
+
+			mapped, ok, err := readMapped(file)
+			if err != nil || !ok {
+				t.Fatalf("data were not memory mapped: %v", err)
+			}
+			buffered, err := readFrom(bytes.NewReader(content))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(mapped.Buffer, buffered.Buffer) {
+				t.Errorf("mapped count data differ from buffered ones")
+			}
+
+			m, b := libmbd.FromRaw(mapped), libmbd.FromRaw(buffered)
+			mappedBlocks, err := m.DataBlocks()
+			if err != nil {
+				t.Fatal(err)
+			}
+			bufferedBlocks, err := b.DataBlocks()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(mappedBlocks, bufferedBlocks) ||
+				!reflect.DeepEqual(m.TimeSpec(), b.TimeSpec()) {
+				t.Errorf("mapped data differ from buffered ones")
+			}
+
+			if !isMapped(t, filename) {
+				t.Fatalf("%s is not mapped", filename)
+			}
+			if err := m.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if isMapped(t, filename) {
+				t.Errorf("%s is still mapped after Close", filename)
+			}
+		})
+	}
+}
+
+// isMapped tests if the named file is memory mapped into this process
+func isMapped(t *testing.T, filename string) bool {
+	maps, err := ioutil.ReadFile("/proc/self/maps")
+	if err != nil {
+		t.Skip(err)
+	}
+	for _, line := range strings.Split(string(maps), "\n") {
+		if strings.HasSuffix(line, " "+filename) {
+			return true
+		}
+	}
+	return false
+}
@@ -5,8 +5,9 @@ package parseAPI1
 
 import (
 	"bytes"
-	"fmt"
 	"io"
+	"io/ioutil"
+	"sort"
 
 	"github.com/haskelladdict/mbdr/libmbd"
 	"github.com/haskelladdict/mbdr/parser/util"
@@ -14,17 +15,17 @@ import (
 
 // Header parses the header without reading the actual data. This provides
 // efficient access to metadata and the names of stored data blocks. After calling
-// this function the buffer field of MCellData is set to nil since no data is parsed.
-func Header(r io.Reader, data *libmbd.MCellData) (*libmbd.MCellData, error) {
+// this function the Buffer field of RawData is set to nil since no data is parsed.
+func Header(r io.Reader, data *libmbd.RawData) (*libmbd.RawData, error) {
 	if err := parseHeader(r, data); err != nil {
 		return nil, err
 	}
 	return data, nil
 }
 
-// Data reads all of the binary count data into MCellData's properly
+// Data reads all of the binary count data into RawData's properly
 // preallocated []byte buffer
-func Data(r io.Reader, data *libmbd.MCellData) (*libmbd.MCellData, error) {
+func Data(r io.Reader, data *libmbd.RawData) (*libmbd.RawData, error) {
 	// compute required capacity of buffer
 	// NOTE: we allocate an additional data.blockSize to avoid re-allocation
 	var capacity uint64
@@ -38,7 +39,10 @@ func Data(r io.Reader, data *libmbd.MCellData) (*libmbd.MCellData, error) {
 			itemLen = util.LenFloat64
 
 		default:
-			return nil, fmt.Errorf("encountered incorrect data type %d", data.BlockEntries[i].Type)
+			e := libmbd.NewError(libmbd.ErrUnknownDataType,
+				"encountered incorrect data type %d", data.BlockEntries[i].Type)
+			e.Block = int64(i)
+			return nil, e
 		}
 		capacity += data.BlockSize * itemLen
 	}
@@ -52,9 +56,86 @@ func Data(r io.Reader, data *libmbd.MCellData) (*libmbd.MCellData, error) {
 	return data, nil
 }
 
+// Selected reads the binary count data of the data blocks for which keep
+// returns true and discards the data of all others. The metadata of RawData
+// are adjusted to only describe the selected data blocks.
+func Selected(r io.Reader, data *libmbd.RawData,
+	keep func(name string) bool) (*libmbd.RawData, error) {
+
+	// data blocks are visited in the order in which they are stored
+	order := make([]int, data.NumBlocks)
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(i, j int) bool {
+		return data.BlockEntries[order[i]].Start < data.BlockEntries[order[j]].Start
+	})
+
+	// copy the selected data blocks. Truncated data are kept as is and reported
+	// when accessing the affected data blocks.
+	var buf bytes.Buffer
+	entries := make(map[int]libmbd.BlockEntry)
+	pos := data.Offset
+	for _, id := range order {
+		entry := data.BlockEntries[id]
+		if !keep(data.BlockNames[id]) {
+			continue
+		}
+		if entry.Start < pos || entry.End < entry.Start {
+			e := libmbd.NewError(libmbd.ErrCorrupt, "data block %d overlaps preceding "+
+				"data", id)
+			e.Block = int64(id)
+			return nil, e
+		}
+
+		if _, err := io.CopyN(ioutil.Discard, r, int64(entry.Start-pos)); err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, err
+		}
+		start := data.Offset + uint64(buf.Len())
+		_, err := io.CopyN(&buf, r, int64(entry.End-entry.Start))
+		entries[id] = libmbd.BlockEntry{Type: entry.Type, Start: start,
+			End: start + entry.End - entry.Start}
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		pos = entry.End
+	}
+	data.Buffer = buf.Bytes()
+
+	// NOTE: Selected data blocks beyond truncated data are retained in the
+	// metadata so accessing them reports the truncation.
+	names := data.BlockNames
+	blockEntries := data.BlockEntries
+	data.BlockNames = nil
+	data.BlockEntries = nil
+	data.BlockNameMap = make(map[string]uint64)
+	end := data.Offset + uint64(buf.Len())
+	for id, n := range names {
+		if !keep(n) {
+			continue
+		}
+		entry, ok := entries[id]
+		if !ok {
+			entry = libmbd.BlockEntry{Type: blockEntries[id].Type, Start: end,
+				End: end + blockEntries[id].End - blockEntries[id].Start}
+			end = entry.End
+		}
+		data.BlockNameMap[n] = uint64(len(data.BlockNames))
+		data.BlockNames = append(data.BlockNames, n)
+		data.BlockEntries = append(data.BlockEntries, entry)
+	}
+	data.NumBlocks = uint64(len(data.BlockNames))
+	return data, nil
+}
+
 // parseBlockInfo reads the pertinent data block information such as the
 // time step, time list, number of data blocks etc.
-func parseBlockInfo(r io.Reader, data *libmbd.MCellData) error {
+func parseBlockInfo(r io.Reader, data *libmbd.RawData) error {
 
 	var err error
 	var outputType uint32
@@ -94,14 +175,15 @@ func parseBlockInfo(r io.Reader, data *libmbd.MCellData) error {
 		}
 
 	default:
-		return fmt.Errorf("encountered unknown data output type")
+		return libmbd.NewError(libmbd.ErrUnknownOutputType,
+			"encountered unknown data output type")
 	}
 
 	return nil
 }
 
 // parseBlockNames extract the names of data blocks contained with the data file
-func parseBlockNames(r io.Reader, data *libmbd.MCellData) error {
+func parseBlockNames(r io.Reader, data *libmbd.RawData) error {
 
 	// initialize blockname map
 	data.BlockNameMap = make(map[string]uint64)
@@ -131,7 +213,7 @@ func parseBlockNames(r io.Reader, data *libmbd.MCellData) error {
 // parseHeader reads the header of the binary mcell data file to check the API
 // version and retrieve general information regarding the data contained
 // within (number of datablocks, block names, ...).
-func parseHeader(r io.Reader, data *libmbd.MCellData) error {
+func parseHeader(r io.Reader, data *libmbd.RawData) error {
 
 	// skip first byte - this is a defect in the mcell binary output format
 	dummy := []byte{0}
@@ -151,11 +233,11 @@ func parseHeader(r io.Reader, data *libmbd.MCellData) error {
 	data.NumBlocks = uint64(numBlocks)
 
 	if err := parseBlockNames(r, data); err != nil {
-		return nil
+		return err
 	}
 
 	if err := parseBlockInfo(r, data); err != nil {
-		return nil
+		return err
 	}
 
 	for i := uint64(0); i < data.NumBlocks; i++ {
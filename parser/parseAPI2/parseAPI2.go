@@ -5,8 +5,8 @@ package parseAPI2
 
 import (
 	"bytes"
-	"fmt"
 	"io"
+	"io/ioutil"
 
 	"github.com/haskelladdict/mbdr/libmbd"
 	"github.com/haskelladdict/mbdr/parser/util"
@@ -14,17 +14,17 @@ import (
 
 // Header parses the header without reading the actual data. This provides
 // efficient access to metadata and the names of stored data blocks. After calling
-// this function the buffer field of MCellData is set to nil since no data is parsed.
-func Header(r io.Reader, data *libmbd.MCellData) (*libmbd.MCellData, error) {
+// this function the Buffer field of RawData is set to nil since no data is parsed.
+func Header(r io.Reader, data *libmbd.RawData) (*libmbd.RawData, error) {
 	if err := parseHeader(r, data); err != nil {
 		return nil, err
 	}
 	return data, nil
 }
 
-// Data reads all of the binary count data into MCellData's properly
+// Data reads all of the binary count data into RawData's properly
 // preallocated []byte buffer
-func Data(r io.Reader, data *libmbd.MCellData) (*libmbd.MCellData, error) {
+func Data(r io.Reader, data *libmbd.RawData) (*libmbd.RawData, error) {
 	// compute required capacity of buffer
 	// NOTE: we allocate an additional data.blockSize to avoid re-allocation
 	var err error
@@ -36,9 +36,68 @@ func Data(r io.Reader, data *libmbd.MCellData) (*libmbd.MCellData, error) {
 	return data, nil
 }
 
+// Selected reads the binary count data of the data blocks for which keep
+// returns true and discards the data of all others. The metadata of RawData
+// are adjusted to only describe the selected data blocks.
+func Selected(r io.Reader, data *libmbd.RawData,
+	keep func(name string) bool) (*libmbd.RawData, error) {
+
+	selected := make([]bool, data.NumBlocks)
+	var info []libmbd.BlockData
+	var totalCols uint64
+	for i, b := range data.BlockInfo {
+		if selected[i] = keep(b.Name); selected[i] {
+			b.Offset = totalCols
+			totalCols += b.NumCols
+			info = append(info, b)
+		}
+	}
+	if data.OutputBufSize == 0 && data.BlockSize != 0 {
+		return nil, libmbd.NewError(libmbd.ErrCorrupt,
+			"encountered invalid output buffer size of 0")
+	}
+
+	// copy the rows of selected data blocks from each stream block. Truncated
+	// data are kept as is and reported when accessing the affected data blocks.
+	buf := bytes.NewBuffer(make([]byte, 0, data.BlockSize*totalCols*util.LenFloat64))
+	for row := uint64(0); row < data.BlockSize; row += data.OutputBufSize {
+		numRows := data.OutputBufSize
+		if data.BlockSize-row < data.OutputBufSize {
+			numRows = data.BlockSize - row
+		}
+		for i, b := range data.BlockInfo {
+			n := int64(numRows * b.NumCols * util.LenFloat64)
+			var err error
+			if selected[i] {
+				_, err = io.CopyN(buf, r, n)
+			} else {
+				_, err = io.CopyN(ioutil.Discard, r, n)
+			}
+			if err == io.EOF {
+				row = data.BlockSize
+				break
+			} else if err != nil {
+				return nil, err
+			}
+		}
+	}
+	data.Buffer = buf.Bytes()
+
+	data.BlockInfo = info
+	data.TotalNumCols = totalCols
+	data.NumBlocks = uint64(len(info))
+	data.BlockNames = nil
+	data.BlockNameMap = make(map[string]uint64)
+	for i, b := range info {
+		data.BlockNames = append(data.BlockNames, b.Name)
+		data.BlockNameMap[b.Name] = uint64(i)
+	}
+	return data, nil
+}
+
 // parseBlockInfo reads the pertinent data block information such as the
 // time step, time list, number of data blocks etc.
-func parseBlockInfo(r io.Reader, data *libmbd.MCellData) error {
+func parseBlockInfo(r io.Reader, data *libmbd.RawData) error {
 
 	var err error
 	if data.OutputListType, err = util.ReadUint16(r); err != nil {
@@ -79,7 +138,8 @@ func parseBlockInfo(r io.Reader, data *libmbd.MCellData) error {
 		}
 
 	default:
-		return fmt.Errorf("encountered unknown data output type")
+		return libmbd.NewError(libmbd.ErrUnknownOutputType,
+			"encountered unknown data output type")
 	}
 
 	if data.OutputBufSize, err = util.ReadUint64(r); err != nil {
@@ -94,7 +154,7 @@ func parseBlockInfo(r io.Reader, data *libmbd.MCellData) error {
 }
 
 // parseBlockNames extract the names of data blocks contained with the data file
-func parseBlockNames(r io.Reader, data *libmbd.MCellData) error {
+func parseBlockNames(r io.Reader, data *libmbd.RawData) error {
 
 	// initialize blockname map
 	data.BlockNameMap = make(map[string]uint64)
@@ -144,7 +204,7 @@ func parseBlockNames(r io.Reader, data *libmbd.MCellData) error {
 // parseHeader reads the header of the binary mcell data file to check the API
 // version and retrieve general information regarding the data contained
 // within (number of datablocks, block names, ...).
-func parseHeader(r io.Reader, data *libmbd.MCellData) error {
+func parseHeader(r io.Reader, data *libmbd.RawData) error {
 
 	// skip first byte - this is a defect in the mcell binary output format
 	dummy := make([]byte, 1)
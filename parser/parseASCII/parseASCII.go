@@ -0,0 +1,87 @@
+// Package parseASCII contains the infrastructure for parsing MCell ASCII
+// reaction data output files (.dat). Each file contains one row per output
+// iteration consisting of the output time followed by one or more data
+// columns.
+package parseASCII
+
+import (
+	"bufio"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/haskelladdict/mbdr/libmbd"
+)
+
+// Data parses the content of a single reaction data file and returns the
+// output times and the count data. Empty lines and comment lines starting
+// with # (e.g. headers written by MCell) are skipped. Columns in which all
+// values are integers are reported as libmbd.IntType, all others as
+// libmbd.DoubleType.
+func Data(r io.Reader) ([]float64, *libmbd.CountData, error) {
+	var times []float64
+	var isInt []bool
+	data := &libmbd.CountData{}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		items := strings.Fields(line)
+		if len(items) < 2 {
+			return nil, nil, libmbd.NewError(libmbd.ErrCorrupt,
+				"line %d: expected time and at least one data column", lineNum)
+		}
+		if data.Col == nil {
+			data.Col = make([][]float64, len(items)-1)
+			isInt = make([]bool, len(items)-1)
+			for i := range isInt {
+				isInt[i] = true
+			}
+		} else if len(items)-1 != len(data.Col) {
+			return nil, nil, libmbd.NewError(libmbd.ErrCorrupt,
+				"line %d: expected %d data columns but found %d", lineNum, len(data.Col),
+				len(items)-1)
+		}
+
+		time, err := strconv.ParseFloat(items[0], 64)
+		if err != nil {
+			return nil, nil, libmbd.NewError(libmbd.ErrCorrupt,
+				"line %d: invalid output time %s", lineNum, items[0])
+		}
+		times = append(times, time)
+
+		for i, item := range items[1:] {
+			if _, err := strconv.ParseInt(item, 10, 64); err != nil {
+				isInt[i] = false
+			}
+			val, err := strconv.ParseFloat(item, 64)
+			if err != nil {
+				return nil, nil, libmbd.NewError(libmbd.ErrCorrupt,
+					"line %d: invalid value %s in column %d", lineNum, item, i+1)
+			}
+			data.Col[i] = append(data.Col[i], val)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, err
+	}
+	if data.Col == nil {
+		return nil, nil, libmbd.NewError(libmbd.ErrEmpty, "no reaction data found")
+	}
+
+	for _, i := range isInt {
+		if i {
+			data.DataTypes = append(data.DataTypes, libmbd.IntType)
+		} else {
+			data.DataTypes = append(data.DataTypes, libmbd.DoubleType)
+		}
+	}
+	return times, data, nil
+}
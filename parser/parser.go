@@ -1,103 +1,158 @@
 // Package parser is a wrapper around the main parsing routines. It figures out
-// the API version of the underlying data and then dispatches the proper parser.
+// the compression format and API version of the underlying data and then
+// dispatches the proper parser registered via RegisterFormat. Metadata of data
+// blocks such as column labels can be attached from sidecar files via
+// LoadMeta.
 package parser
 
 import (
 	"compress/bzip2"
-	"fmt"
+	"compress/gzip"
+	"errors"
 	"io"
 	"os"
 
 	"github.com/haskelladdict/mbdr/libmbd"
-	"github.com/haskelladdict/mbdr/parser/parseAPI1"
-	"github.com/haskelladdict/mbdr/parser/parseAPI2"
 )
 
-const apiTagLength = len("MCELL_BINARY_API_2")
-
 // ReadHeader opens the binary mcell data file and parses the header without
 // reading the actual data. This provides efficient access to metadata and
-// the names of stored data blocks. After calling this function the buffer
-// field of MCellData is set to nil since no data is parsed.
+// the names of stored data blocks. The returned MCellData hold no count data
+// since none are parsed.
+// If the file has an up to date sidecar index (see WriteIndex) the header is
+// taken from the latter without decompressing the file.
+// NOTE: Directories and .dat files are read as MCell ASCII reaction data
+// output via ReadASCII.
 func ReadHeader(filename string) (*libmbd.MCellData, error) {
-	fileRaw, err := os.Open(filename)
+	if isASCII(filename) {
+		return ReadASCII(filename)
+	}
+	if raw, ok := readIndex(filename); ok {
+		return libmbd.FromRaw(raw), nil
+	}
+
+	file, err := os.Open(filename)
 	if err != nil {
 		return nil, err
 	}
-	defer fileRaw.Close()
-	file := bzip2.NewReader(fileRaw)
+	defer file.Close()
 
-	// check API version and pick proper reader
-	apiTag, err := parseAPITag(file)
+	raw, err := readHeaderFrom(file)
 	if err != nil {
-		return nil, err
+		return nil, libmbd.WithFile(err, filename)
 	}
+	raw.FileName = filename
+	return libmbd.FromRaw(raw), nil
+}
 
-	data := new(libmbd.MCellData)
-	data.API = apiTag
-	switch apiTag {
-	case libmbd.API1:
-		if data, err = parseAPI1.Header(file, data); err != nil {
-			return nil, err
-		}
-	case libmbd.API2:
-		if data, err = parseAPI2.Header(file, data); err != nil {
-			return nil, err
-		}
-	default:
-		return nil, fmt.Errorf("unknown mcell binary api version %s", apiTag)
+// ReadHeaderFrom parses the header of the binary mcell data provided by the
+// io.Reader without reading the actual data. See ReadHeader for details.
+func ReadHeaderFrom(r io.Reader) (*libmbd.MCellData, error) {
+	raw, err := readHeaderFrom(r)
+	if err != nil {
+		return nil, err
 	}
+	return libmbd.FromRaw(raw), nil
+}
 
-	return data, nil
+// readHeaderFrom parses the header of the binary mcell data provided by the
+// io.Reader into RawData
+func readHeaderFrom(r io.Reader) (*libmbd.RawData, error) {
+	file, closer, err := decompress(r)
+	if err != nil {
+		return nil, err
+	}
+	defer closer.Close()
 
+	data, _, err := parseHeader(file)
+	return data, err
 }
 
 // Read header opens the binary mcell data file and parses the header and the
 // actual data stored. If only access to the metadata is required, it is much
 // more efficient to only call ReadHeader directly.
+// NOTE: On Linux, the data of uncompressed files are memory mapped instead of
+// read into memory. Call Close on the returned MCellData to release the
+// mapping once the data are no longer needed.
+// NOTE: Directories and .dat files are read as MCell ASCII reaction data
+// output via ReadASCII.
 func Read(filename string) (*libmbd.MCellData, error) {
-	fileRaw, err := os.Open(filename)
+	if isASCII(filename) {
+		return ReadASCII(filename)
+	}
+
+	file, err := os.Open(filename)
 	if err != nil {
 		return nil, err
 	}
-	defer fileRaw.Close()
-	file := bzip2.NewReader(fileRaw)
+	defer file.Close()
 
-	// check API version and pick proper reader
-	apiTag, err := parseAPITag(file)
+	raw, ok, err := readMapped(file)
+	if !ok && err == nil {
+		raw, err = readFrom(file)
+	}
 	if err != nil {
-		return nil, fmt.Errorf("Failed to parse API tag of file %s  - file empty??",
-			filename)
-	}
-
-	data := new(libmbd.MCellData)
-	data.API = apiTag
-	switch apiTag {
-	case libmbd.API1:
-		if data, err = parseAPI1.Header(file, data); err != nil {
-			return nil, err
-		}
-		if data, err = parseAPI1.Data(file, data); err != nil {
-			return nil, err
-		}
-	case libmbd.API2:
-		if data, err = parseAPI2.Header(file, data); err != nil {
-			return nil, err
-		}
-		if data, err = parseAPI2.Data(file, data); err != nil {
-			return nil, err
-		}
-	default:
-		return nil, fmt.Errorf("unknown mcell binary api version %s", apiTag)
+		return nil, libmbd.WithFile(err, filename)
+	}
+	raw.FileName = filename
+	return libmbd.FromRaw(raw), nil
+}
+
+// ReadFrom parses the header and the actual data of the binary mcell data
+// provided by the io.Reader. This allows reading data from sources other than
+// plain files such as pipes or in-memory buffers. The compression format
+// (gzip, bzip2, none, or any registered via RegisterDecompressor) is detected
+// automatically.
+func ReadFrom(r io.Reader) (*libmbd.MCellData, error) {
+	raw, err := readFrom(r)
+	if err != nil {
+		return nil, err
+	}
+	return libmbd.FromRaw(raw), nil
+}
+
+// readFrom parses the header and the actual data of the binary mcell data
+// provided by the io.Reader into RawData
+func readFrom(r io.Reader) (*libmbd.RawData, error) {
+	file, closer, err := decompress(r)
+	if err != nil {
+		return nil, err
+	}
+	defer closer.Close()
+
+	data, f, err := parseHeader(file)
+	if err != nil {
+		return nil, err
+	}
+	if data, err = f.Data(file, data); err != nil {
+		return nil, classify(err, "failed to read count data")
 	}
 	return data, nil
 }
 
-// parseAPITag reads the API tag inside the data set
-func parseAPITag(r io.Reader) (string, error) {
-	receivedAPITag := make([]byte, apiTagLength)
-	if n, err := io.ReadFull(r, receivedAPITag); err != nil || n == 0 {
-		return "", err
+// emptyError returns the error reported if the API tag could not be parsed
+func emptyError() error {
+	return libmbd.NewError(libmbd.ErrEmpty, "failed to parse API tag - file empty??")
+}
+
+// classify turns I/O and decompression errors encountered while parsing into
+// the corresponding *libmbd.Error. Errors which already are of type
+// *libmbd.Error are returned unchanged.
+func classify(err error, msg string) error {
+	var e *libmbd.Error
+	if errors.As(err, &e) {
+		return err
+	}
+
+	var bzErr bzip2.StructuralError
+	switch {
+	case err == io.EOF || err == io.ErrUnexpectedEOF:
+		e = libmbd.NewError(libmbd.ErrTruncated, "%s", msg)
+	case errors.As(err, &bzErr) || err == gzip.ErrChecksum || err == gzip.ErrHeader:
+		e = libmbd.NewError(libmbd.ErrCorrupt, "%s", msg)
+	default:
+		return err
 	}
-	return string(receivedAPITag), nil
+	e.Err = err
+	return e
 }
@@ -0,0 +1,240 @@
+package parser
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/haskelladdict/mbdr/libmbd"
+)
+
+// api1Block is a single column data block of an MCELL_BINARY_API_1 file.
+// Type 0 holds integer data stored as uint32, type 1 doubles.
+type api1Block struct {
+	name string
+	typ  byte
+	col  []float64
+}
+
+// writeAPI1 returns the provided data blocks encoded as MCELL_BINARY_API_1
+// file with the given output times
+func writeAPI1(blocks []api1Block, spec libmbd.TimeSpec, blockSize uint64) []byte {
+	var header bytes.Buffer
+	le := binary.LittleEndian
+	header.WriteString(libmbd.API1 + "\x00")
+	binary.Write(&header, le, blockSize)
+	binary.Write(&header, le, uint32(len(blocks)))
+	for _, b := range blocks {
+		header.WriteString(b.name + "\x00")
+	}
+	binary.Write(&header, le, uint32(spec.OutputListType-1))
+	if spec.OutputListType == libmbd.Step {
+		binary.Write(&header, le, uint64(1))
+		binary.Write(&header, le, spec.StepSize)
+	} else {
+		binary.Write(&header, le, uint64(len(spec.TimeList)))
+		binary.Write(&header, le, spec.TimeList)
+	}
+
+	var data bytes.Buffer
+	for _, b := range blocks {
+		for _, v := range b.col {
+			if b.typ == 0 {
+				binary.Write(&data, le, uint32(v))
+			} else {
+				binary.Write(&data, le, v)
+			}
+		}
+	}
+
+	// block entries hold the absolute location of each data block
+	pos := uint64(header.Len() + len(blocks)*17)
+	for _, b := range blocks {
+		itemLen := uint64(8)
+		if b.typ == 0 {
+			itemLen = 4
+		}
+		header.WriteByte(b.typ)
+		binary.Write(&header, le, pos)
+		binary.Write(&header, le, pos+uint64(len(b.col))*itemLen)
+		pos += uint64(len(b.col)) * itemLen
+	}
+	return append(header.Bytes(), data.Bytes()...)
+}
+
+// writeAPI2 returns the provided data blocks encoded as MCELL_BINARY_API_2
+// file
+func writeAPI2(t *testing.T, blocks []libmbd.DataBlock, spec libmbd.TimeSpec,
+	bufSize uint64) []byte {
+
+	var buf bytes.Buffer
+	if err := libmbd.WriteAPI2(&buf, blocks, spec, bufSize); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// seq returns n values start, start+step, ...
+func seq(n int, start, step float64) []float64 {
+	s := make([]float64, n)
+	for i := range s {
+		s[i] = start + float64(i)*step
+	}
+	return s
+}
+
+// api2Fixture returns data blocks with single and multi column blocks of
+// integer and double data and n rows each
+func api2Fixture(n int) []libmbd.DataBlock {
+	return []libmbd.DataBlock{
+		{Name: "bound_A", Data: &libmbd.CountData{Col: [][]float64{seq(n, 0, 1)},
+			DataTypes: []uint16{libmbd.IntType}}},
+		{Name: "mixed", Data: &libmbd.CountData{
+			Col:       [][]float64{seq(n, 0.5, 0.25), seq(n, 7, 3), seq(n, -1, -1e-3)},
+			DataTypes: []uint16{libmbd.DoubleType, libmbd.IntType, libmbd.DoubleType}}},
+		{Name: "free", Data: &libmbd.CountData{Col: [][]float64{seq(n, 1e6, -3)},
+			DataTypes: []uint16{libmbd.IntType}}},
+	}
+}
+
+// TestRoundTripAPI2 converts API1 and API2 data to API2 and checks that the
+// result parses to the same header, data block names, data types, output
+// times, and values
+func TestRoundTripAPI2(t *testing.T) {
+	api1Blocks := []api1Block{
+		{"ints", 0, seq(7, 3, 2)},
+		{"doubles", 1, seq(7, 0.1, 1.0/3)},
+		{"more_ints", 0, seq(7, 4e9, 1)},
+	}
+	timeList := libmbd.TimeSpec{OutputListType: libmbd.TimeListType,
+		TimeList: []float64{0, 1e-6, 3e-6, 4e-6, 1e-5, 2e-5, 2.5e-5}}
+	iterList := libmbd.TimeSpec{OutputListType: libmbd.IterationListType,
+		TimeList: seq(7, 10, 10)}
+	step := libmbd.TimeSpec{OutputListType: libmbd.Step, StepSize: 1e-6}
+
+	tests := []struct {
+		name    string
+		input   []byte
+		bufSize uint64 // output buffer size of the converted file
+	}{
+		{"API1 step", writeAPI1(api1Blocks, step, 7), 3},
+		{"API1 time list", writeAPI1(api1Blocks, timeList, 7), 10},
+		{"API1 iteration list", writeAPI1(api1Blocks, iterList, 7), 7},
+		{"API2 step", writeAPI2(t, api2Fixture(25), step, 10), 10},
+		{"API2 single stream block", writeAPI2(t, api2Fixture(25), step, 100), 100},
+		{"API2 rechunked", writeAPI2(t, api2Fixture(25), step, 10), 4},
+		{"API2 time list", writeAPI2(t, api2Fixture(7), timeList, 3), 3},
+		{"API2 empty", writeAPI2(t, api2Fixture(0), step, 10), 10},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			orig, err := ReadFrom(bytes.NewReader(tt.input))
+			if err != nil {
+				t.Fatal(err)
+			}
+			blocks, err := orig.DataBlocks()
+			if err != nil {
+				t.Fatal(err)
+			}
+			var buf bytes.Buffer
+			if err := libmbd.WriteAPI2(&buf, blocks, orig.TimeSpec(), tt.bufSize); err != nil {
+				t.Fatal(err)
+			}
+			conv, err := ReadFrom(bytes.NewReader(buf.Bytes()))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// API2 input written with its own buffer size is reproduced exactly
+			if orig.API() == libmbd.API2 && orig.OutputBufSize() == tt.bufSize &&
+				!bytes.Equal(buf.Bytes(), tt.input) {
+				t.Errorf("converted file differs from original")
+			}
+
+			if conv.API() != libmbd.API2 || conv.OutputBufSize() != tt.bufSize {
+				t.Errorf("got API %s with buffer size %d, want %s with %d", conv.API(),
+					conv.OutputBufSize(), libmbd.API2, tt.bufSize)
+			}
+			if conv.OutputType() != orig.OutputType() || conv.BlockLen() != orig.BlockLen() ||
+				conv.OutputStepLen() != orig.OutputStepLen() {
+				t.Errorf("got header (%d, %d, %g), want (%d, %d, %g)", conv.OutputType(),
+					conv.BlockLen(), conv.OutputStepLen(), orig.OutputType(), orig.BlockLen(),
+					orig.OutputStepLen())
+			}
+			if !reflect.DeepEqual(conv.DataNames(), orig.DataNames()) {
+				t.Errorf("got names %v, want %v", conv.DataNames(), orig.DataNames())
+			}
+			if !sameBits(conv.OutputTimes(), orig.OutputTimes()) {
+				t.Errorf("got output times %v, want %v", conv.OutputTimes(),
+					orig.OutputTimes())
+			}
+
+			for id, n := range orig.DataNames() {
+				want, err := orig.BlockDataByID(uint64(id))
+				if err != nil {
+					t.Fatal(err)
+				}
+				got, err := conv.BlockDataByName(n)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !reflect.DeepEqual(got.DataTypes, want.DataTypes) {
+					t.Errorf("%s: got data types %v, want %v", n, got.DataTypes,
+						want.DataTypes)
+				}
+				if len(got.Col) != len(want.Col) {
+					t.Fatalf("%s: got %d columns, want %d", n, len(got.Col), len(want.Col))
+				}
+				for c := range want.Col {
+					if !sameBits(got.Col[c], want.Col[c]) {
+						t.Errorf("%s: column %d differs", n, c)
+					}
+				}
+			}
+		})
+	}
+}
+
+// TestAPI1DataTypes checks that the API1 data types (0 for uint32, 1 for
+// double) are translated into the API2 data types IntType and DoubleType
+// rather than being passed through unchanged, and that the values are decoded
+// accordingly
+func TestAPI1DataTypes(t *testing.T) {
+	blocks := []api1Block{{"ints", 0, []float64{1, 2, 4294967295}},
+		{"doubles", 1, []float64{0.5, -1, 1e300}}}
+	data, err := ReadFrom(bytes.NewReader(writeAPI1(blocks,
+		libmbd.TimeSpec{OutputListType: libmbd.Step, StepSize: 1}, 3)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wantTypes := []uint16{libmbd.IntType, libmbd.DoubleType}
+	for id, b := range blocks {
+		c, err := data.BlockDataByID(uint64(id))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(c.DataTypes) != 1 || c.DataTypes[0] != wantTypes[id] {
+			t.Errorf("%s: got data types %v, want [%d]", b.name, c.DataTypes, wantTypes[id])
+		}
+		if !sameBits(c.Col[0], b.col) {
+			t.Errorf("%s: got %v, want %v", b.name, c.Col[0], b.col)
+		}
+	}
+}
+
+// sameBits tests if both slices hold bitwise identical values
+func sameBits(a, b []float64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if math.Float64bits(a[i]) != math.Float64bits(b[i]) {
+			return false
+		}
+	}
+	return true
+}
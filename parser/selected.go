@@ -0,0 +1,177 @@
+package parser
+
+import (
+	"io"
+	"os"
+	"regexp"
+
+	"github.com/haskelladdict/mbdr/libmbd"
+)
+
+// Selector decides based on its name whether a data block is loaded by
+// ReadSelected. Any predicate on block names can be used as Selector.
+type Selector func(name string) bool
+
+// SelectNames returns a Selector choosing the data blocks with the given
+// names
+func SelectNames(names ...string) Selector {
+	set := make(map[string]bool)
+	for _, n := range names {
+		set[n] = true
+	}
+	return func(name string) bool {
+		return set[name]
+	}
+}
+
+// SelectRegex returns a Selector choosing all data blocks whose name matches
+// the provided regular expression
+func SelectRegex(expr string) (Selector, error) {
+	regex, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+	return regex.MatchString, nil
+}
+
+// ReadSelected opens the binary mcell data file and parses the header as well
+// as the count data of all data blocks chosen by sel. The data of all other
+// data blocks are discarded while reading so that only memory proportional to
+// the number of selected columns is required. The returned MCellData only
+// describes the selected data blocks.
+// NOTE: On Linux, uncompressed files are memory mapped as in Read and
+// restricted to the selected data blocks without copying any count data. Call
+// Close on the returned MCellData to release the mapping.
+// NOTE: Directories and .dat files are read as MCell ASCII reaction data
+// output via ReadASCII and filtered afterwards.
+func ReadSelected(filename string, sel Selector) (*libmbd.MCellData, error) {
+	if isASCII(filename) {
+		data, err := ReadASCII(filename)
+		if err != nil {
+			return nil, err
+		}
+		raw, err := selectBlocks(data, sel)
+		if err != nil {
+			return nil, err
+		}
+		return libmbd.FromRaw(raw), nil
+	}
+
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	raw, ok, err := readMapped(file)
+	if ok && err == nil {
+		raw = selectMapped(raw, sel)
+	} else if !ok && err == nil {
+		raw, err = readSelectedFrom(file, sel)
+	}
+	if err != nil {
+		return nil, libmbd.WithFile(err, filename)
+	}
+	raw.FileName = filename
+	return libmbd.FromRaw(raw), nil
+}
+
+// ReadSelectedFrom parses the header and the count data of the data blocks
+// chosen by sel from the binary mcell data provided by the io.Reader. See
+// ReadSelected for details.
+func ReadSelectedFrom(r io.Reader, sel Selector) (*libmbd.MCellData, error) {
+	raw, err := readSelectedFrom(r, sel)
+	if err != nil {
+		return nil, err
+	}
+	return libmbd.FromRaw(raw), nil
+}
+
+// readSelectedFrom parses the header and the count data of the data blocks
+// chosen by sel from the binary mcell data provided by the io.Reader
+func readSelectedFrom(r io.Reader, sel Selector) (*libmbd.RawData, error) {
+	file, closer, err := decompress(r)
+	if err != nil {
+		return nil, err
+	}
+	defer closer.Close()
+
+	data, f, err := parseHeader(file)
+	if err != nil {
+		return nil, err
+	}
+
+	if f.Selected == nil {
+		if data, err = f.Data(file, data); err != nil {
+			return nil, classify(err, "failed to read count data")
+		}
+		return selectBlocks(libmbd.FromRaw(data), sel)
+	}
+
+	if data, err = f.Selected(file, data, sel); err != nil {
+		return nil, classify(err, "failed to read count data")
+	}
+	return data, nil
+}
+
+// selectMapped restricts memory mapped data to the data blocks chosen by sel.
+// The count data of the other data blocks stay mapped but are never accessed.
+// Since the data blocks keep their original location within the count data
+// only the header information describing them is adjusted.
+func selectMapped(data *libmbd.RawData, sel Selector) *libmbd.RawData {
+	var names []string
+	var entries []libmbd.BlockEntry
+	var info []libmbd.BlockData
+	var meta []libmbd.BlockMeta
+	nameMap := make(map[string]uint64)
+	for id, n := range data.BlockNames {
+		if !sel(n) {
+			continue
+		}
+		nameMap[n] = uint64(len(names))
+		names = append(names, n)
+		if id < len(data.BlockEntries) {
+			entries = append(entries, data.BlockEntries[id])
+		}
+		if id < len(data.BlockInfo) {
+			info = append(info, data.BlockInfo[id])
+		}
+		if data.Meta != nil {
+			meta = append(meta, data.Meta[id])
+		}
+	}
+
+	data.NumBlocks = uint64(len(names))
+	data.BlockNames, data.BlockNameMap, data.Meta = names, nameMap, meta
+	data.BlockEntries, data.BlockInfo = entries, info
+	return data
+}
+
+// selectBlocks returns new in-memory data containing only the data blocks of
+// data chosen by sel
+func selectBlocks(data *libmbd.MCellData, sel Selector) (*libmbd.RawData, error) {
+	defer data.Close()
+
+	var blocks []libmbd.DataBlock
+	for id, n := range data.DataNames() {
+		if !sel(n) {
+			continue
+		}
+		countData, err := data.BlockDataByID(uint64(id))
+		if err != nil {
+			return nil, err
+		}
+		blocks = append(blocks, libmbd.DataBlock{Name: n, Data: countData})
+	}
+
+	selected, err := libmbd.NewRawData(blocks, data.TimeSpec())
+	if err != nil {
+		return nil, err
+	}
+	selected.BlockSize = data.BlockLen()
+	if data.API() == libmbd.ASCII {
+		selected.API = libmbd.ASCII
+	}
+	selected.FileName = data.FileName()
+	return selected, nil
+}
@@ -0,0 +1,75 @@
+package parser
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+
+	"github.com/haskelladdict/mbdr/libmbd"
+)
+
+// TestReadSelected checks that ReadSelected returns the same data as Read for
+// the selected data blocks of uncompressed API1 and API2 files
+func TestReadSelected(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mbdr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	step := libmbd.TimeSpec{OutputListType: libmbd.Step, StepSize: 1e-6}
+	files := map[string][]byte{
+		"api1.bin": writeAPI1([]api1Block{{"ints", 0, seq(7, 3, 2)},
+			{"doubles", 1, seq(7, 0.1, 0.5)}, {"more_ints", 0, seq(7, 9, 1)}}, step, 7),
+		"api2.bin": writeAPI2(t, api2Fixture(25), step, 10),
+	}
+	for name, content := range files {
+		filename := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(filename, content, 0644); err != nil {
+			t.Fatal(err)
+		}
+		all, err := Read(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer all.Close()
+
+		for _, sel := range [][]string{{}, {"more_ints", "free"}, all.DataNames()} {
+			data, err := ReadSelected(filename, SelectNames(sel...))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if runtime.GOOS == "linux" && !data.External() {
+				t.Errorf("%s: uncompressed data were not memory mapped", name)
+			}
+
+			var want []string
+			for _, n := range all.DataNames() {
+				if SelectNames(sel...)(n) {
+					want = append(want, n)
+				}
+			}
+			if len(data.DataNames()) != len(want) ||
+				(len(want) != 0 && !reflect.DeepEqual(data.DataNames(), want)) {
+				t.Errorf("%s: got data blocks %v, want %v", name, data.DataNames(), want)
+			}
+			for _, n := range want {
+				got, err := data.BlockDataByName(n)
+				if err != nil {
+					t.Fatal(err)
+				}
+				c, err := all.BlockDataByName(n)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !reflect.DeepEqual(got, c) {
+					t.Errorf("%s: data block %s differs", name, n)
+				}
+			}
+			data.Close()
+		}
+	}
+}
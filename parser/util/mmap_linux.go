@@ -0,0 +1,24 @@
+//go:build linux
+
+package util
+
+import (
+	"os"
+	"syscall"
+)
+
+// Mmap maps the complete content of the provided file read-only into memory.
+// The returned buffer has to be released via Munmap once it is no longer in
+// use.
+func Mmap(file *os.File, size int64) (ReadBuf, error) {
+	if size <= 0 || int64(int(size)) != size {
+		return nil, ErrMmapUnsupported
+	}
+	return syscall.Mmap(int(file.Fd()), 0, int(size), syscall.PROT_READ,
+		syscall.MAP_SHARED)
+}
+
+// Munmap releases a buffer previously obtained via Mmap
+func Munmap(buf ReadBuf) error {
+	return syscall.Munmap(buf)
+}
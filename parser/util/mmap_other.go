@@ -0,0 +1,16 @@
+//go:build !linux
+
+package util
+
+import "os"
+
+// Mmap is not supported on this platform and always returns
+// ErrMmapUnsupported
+func Mmap(file *os.File, size int64) (ReadBuf, error) {
+	return nil, ErrMmapUnsupported
+}
+
+// Munmap is not supported on this platform
+func Munmap(buf ReadBuf) error {
+	return ErrMmapUnsupported
+}
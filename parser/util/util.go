@@ -4,6 +4,7 @@ package util
 import (
 	"bytes"
 	"encoding/binary"
+	"errors"
 	"io"
 	"math"
 )
@@ -17,6 +18,9 @@ const (
 	LenFloat64 = 8
 )
 
+// ErrMmapUnsupported is returned by Mmap if a file can not be memory mapped
+var ErrMmapUnsupported = errors.New("memory mapping is not supported")
+
 // ReadBuf and helper function convert between a byte slice and an underlying
 // integer type
 // NOTE: This code was take almost verbatim from archive/zip/reader from the
@@ -115,3 +119,24 @@ func ReadAll(r io.Reader, capacity int64) (b []byte, err error) {
 	_, err = buf.ReadFrom(r)
 	return buf.Bytes(), err
 }
+
+// WriteUint16 writes an uint16 to an io.Writer
+func WriteUint16(w io.Writer, v uint16) error {
+	buf := make([]byte, LenUint16)
+	binary.LittleEndian.PutUint16(buf, v)
+	_, err := w.Write(buf)
+	return err
+}
+
+// WriteUint64 writes an uint64 to an io.Writer
+func WriteUint64(w io.Writer, v uint64) error {
+	buf := make([]byte, LenUint64)
+	binary.LittleEndian.PutUint64(buf, v)
+	_, err := w.Write(buf)
+	return err
+}
+
+// WriteFloat64 writes a float64 to an io.Writer
+func WriteFloat64(w io.Writer, v float64) error {
+	return WriteUint64(w, math.Float64bits(v))
+}
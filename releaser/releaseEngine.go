@@ -250,7 +250,10 @@ func extractActivationEvents(data *libmbd.MCellData, m *SimModel, fusion *Fusion
 			}
 		}
 
-		sensorData := make([]int, data.BlockLen())
+		// NOTE: Integer binding counts are summed exactly. Double data (e.g.
+		// binding counts averaged over several runs) are summed as is and
+		// compared against the activation threshold without rounding.
+		sensorData := make([]float64, data.BlockLen())
 		for _, dataName := range dataNames {
 			bd, err := data.BlockDataByName(dataName)
 			if err != nil {
@@ -261,18 +264,33 @@ func extractActivationEvents(data *libmbd.MCellData, m *SimModel, fusion *Fusion
 				return nil, fmt.Errorf("data set %s had more than one data column",
 					dataName)
 			}
-			for i := 0; i < len(sensorData); i++ {
-				sensorData[i] += int(bd.Col[0][i])
+			if bd.IsInt(0) {
+				counts, err := bd.IntCol(0)
+				if err != nil {
+					return nil, err
+				}
+				for i := 0; i < len(sensorData); i++ {
+					sensorData[i] += float64(counts[i])
+				}
+			} else {
+				values, err := bd.FloatCol(0)
+				if err != nil {
+					return nil, err
+				}
+				for i := 0; i < len(sensorData); i++ {
+					sensorData[i] += values[i]
+				}
 			}
 		}
 
 		// check for activation events
+		threshold := float64(actThresh)
 		active := false
 		for i, b := range sensorData {
-			if !active && b >= actThresh {
+			if !active && b >= threshold {
 				active = true
 				events = append(events, ActEvent{id, vesicleID, i, active})
-			} else if active && b < actThresh {
+			} else if active && b < threshold {
 				active = false
 				events = append(events, ActEvent{id, vesicleID, i, active})
 			}
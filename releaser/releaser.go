@@ -3,10 +3,12 @@
 package releaser
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"math/rand"
 	"os"
+	"regexp"
 	"runtime"
 	"runtime/debug"
 	"strconv"
@@ -36,6 +38,11 @@ func Run(model *SimModel, fusion *FusionModel, info *AnalyzerInfo, args []string
 
 	runtime.GOMAXPROCS(info.NumThreads)
 
+	sel, err := analysisSelector(model)
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	printHeader(model, fusion, info)
 	analysisJobs := make(chan string)
 	go createAnalysisJobs(args, analysisJobs)
@@ -44,7 +51,7 @@ func Run(model *SimModel, fusion *FusionModel, info *AnalyzerInfo, args []string
 	var runWg sync.WaitGroup
 	for i := 0; i < info.NumThreads; i++ {
 		runWg.Add(1)
-		go runJob(analysisJobs, model, fusion, output, &runWg)
+		go runJob(analysisJobs, model, fusion, sel, output, &runWg)
 	}
 
 	// close done channel once all jobs are finished
@@ -67,41 +74,109 @@ func Run(model *SimModel, fusion *FusionModel, info *AnalyzerInfo, args []string
 	printErrors(errs)
 }
 
+// analysisSelector returns a Selector choosing the data blocks required for
+// the release analysis, i.e. the vesicle sensor binding data named according
+// to the model's SensorTemplate and the calcium binding data of vesicles
+// (see caPattern and determineCaChanContrib)
+func analysisSelector(m *SimModel) (parser.Selector, error) {
+	sensorRegex, err := regexp.Compile(templateRegex(m.SensorTemplate))
+	if err != nil {
+		return nil, fmt.Errorf("invalid sensor template %q: %s", m.SensorTemplate, err)
+	}
+	return func(name string) bool {
+		if sensorRegex.MatchString(name) {
+			return true
+		}
+		_, ok := caPattern.Parse(name)
+		return ok
+	}, nil
+}
+
+// templateRegex turns a fmt format string into a regular expression matching
+// all strings it can produce. %d verbs (with optional flags and width) match
+// integers, all other verbs any non-empty string.
+func templateRegex(template string) string {
+	var expr strings.Builder
+	expr.WriteString("^")
+	for len(template) > 0 {
+		i := strings.IndexByte(template, '%')
+		if i < 0 || i == len(template)-1 {
+			expr.WriteString(regexp.QuoteMeta(template))
+			break
+		}
+		expr.WriteString(regexp.QuoteMeta(template[:i]))
+
+		// skip flags, width, and precision up to the verb
+		j := i + 1
+		for j < len(template)-1 && strings.IndexByte("+-# 0123456789.", template[j]) >= 0 {
+			j++
+		}
+		switch template[j] {
+		case '%':
+			expr.WriteString("%")
+		case 'd':
+			expr.WriteString(" *[-+]?[0-9]+")
+		default:
+			expr.WriteString(".+")
+		}
+		template = template[j+1:]
+	}
+	expr.WriteString("$")
+	return expr.String()
+}
+
 // runJob is responsible for analyzing the data files provided in the
-// analysisJob channel
+// analysisJob channel. Only the data blocks chosen by sel are read.
 func runJob(analysisJobs <-chan string, m *SimModel, f *FusionModel,
-	output chan<- Output, wg *sync.WaitGroup) {
+	sel parser.Selector, output chan<- Output, wg *sync.WaitGroup) {
 
 	rng := rand.New(rand.NewSource(time.Now().UnixNano()))
 
 	for fileName := range analysisJobs {
 		seed, err := extractSeed(fileName)
 		if err != nil {
-			output <- Output{fmt.Errorf("%s: %s", fileName, err), nil}
+			output <- Output{jobError(fileName, err), nil}
 			continue
 		}
 
-		data, err := parser.Read(fileName)
+		data, err := parser.ReadSelected(fileName, sel)
 		if err != nil {
-			output <- Output{fmt.Errorf("%s: %s", fileName, err), nil}
+			output <- Output{jobError(fileName, err), nil}
 			continue
 		}
 
 		releaseMsgs, err := analyze(data, m, f, rng, seed)
+		// NOTE: This is a bit of a hack but since we're dealing with potentially
+		// large data sets we need to make sure to free memory before we start
+		// working on the next one. The data have to be closed and unreachable
+		// for this to work. Memory mapped data live in the page cache and are
+		// released by Close instead.
+		mapped := data.External()
+		data.Close()
+		data = nil
+		if !mapped {
+			debug.FreeOSMemory()
+		}
 		if err != nil {
-			output <- Output{fmt.Errorf("%s: %s", fileName, err), nil}
+			output <- Output{jobError(fileName, err), nil}
 			continue
 		}
-		// NOTE: This is a bit of a hack but since we're dealing with potentially
-		// large data sets we need to make sure to free memory before we start
-		// working on the next one
-		debug.FreeOSMemory()
 
 		output <- Output{nil, releaseMsgs}
 	}
 	wg.Done()
 }
 
+// jobError adds the name of the analyzed file to err unless it already
+// carries it
+func jobError(fileName string, err error) error {
+	var e *libmbd.Error
+	if errors.As(err, &e) {
+		return libmbd.WithFile(err, fileName)
+	}
+	return fmt.Errorf("%s: %w", fileName, err)
+}
+
 // extractSeed attempts to extract the seed from the filename of the provided
 // binary mcell data file.
 // NOTE: the following filenaming convention is assumed *.<seedIDString>.bin.(gz|bz2)
@@ -149,12 +224,24 @@ func printHeader(model *SimModel, fusion *FusionModel, info *AnalyzerInfo) {
 }
 
 // printErrors prints out all encountered errors (if any) to stdout
-func printErrors(errors []error) {
-	if len(errors) != 0 {
+func printErrors(errs []error) {
+	if len(errs) != 0 {
 		fmt.Println("\n\n------------------------------------------")
-		fmt.Printf("ERROR: %d output files could not be processed!\n", len(errors))
+		fmt.Printf("ERROR: %d output files could not be processed!\n", len(errs))
+
+		var numCorrupt, numMissing int
+		for _, e := range errs {
+			switch {
+			case errors.Is(e, libmbd.ErrTruncated) || errors.Is(e, libmbd.ErrCorrupt):
+				numCorrupt++
+			case errors.Is(e, libmbd.ErrDatasetNotFound):
+				numMissing++
+			}
+		}
+		fmt.Printf("%d files are corrupt or truncated, %d files lack required data sets\n",
+			numCorrupt, numMissing)
 		fmt.Println("\nReason:")
-		for _, e := range errors {
+		for _, e := range errs {
 			fmt.Println(e)
 		}
 	}
@@ -178,6 +265,10 @@ func checkInput(model *SimModel, fusion *FusionModel) error {
 	return nil
 }
 
+// caPattern describes the names of data sets tracking Ca binding to vesicles
+var caPattern = libmbd.MustCompileNamePattern(
+	`.*vesicle(?:_Y)?_(?P<vesicle>.+?)_ca_(?P<channel>[^.]*)(?:\..*)?`)
+
 // determineCaContrib determines which Ca channels contributed to the release
 // of a particular vesicle.
 // NOTE: We try to be as agnostic as we can in terms of the particular
@@ -187,43 +278,29 @@ func checkInput(model *SimModel, fusion *FusionModel) error {
 // vesicle_Y_<az>_<1|2>_ca_<ca naming>.<seed>.dat for Y.
 func determineCaChanContrib(data *libmbd.MCellData, rel *ReleaseEvent) (map[string]float64, error) {
 	channels := make(map[string]float64)
-	regexString := fmt.Sprintf("vesicle(_Y)?_%s_ca_.*", rel.vesicleID)
-	counts, err := data.BlockDataByRegex(regexString)
-	if err != nil {
-		return nil, err
-	}
-	for k, c := range counts {
+	names := data.SelectByKeys(caPattern, map[string]string{"vesicle": rel.vesicleID})
+	for _, n := range names {
+		c, err := data.BlockDataByName(n)
+		if err != nil {
+			return nil, err
+		}
 		if len(c.Col) != 1 {
 			return nil, fmt.Errorf("data set %s has more than the expected 1 column",
-				k)
+				n)
 		}
 		if c.Col[0][rel.eventIter] > 0 {
-			// need to subtract 2 from regexString due to the extra ".*"
-			subs := strings.SplitAfter(k, "ca_")
-			if len(subs) < 2 {
-				return nil, fmt.Errorf("could not determined Ca channel name")
+			keys, _ := caPattern.Parse(n)
+			if keys["channel"] == "" {
+				return nil, fmt.Errorf("Could not determine Ca channel name from data set %s",
+					n)
 			}
-			caString, err := extractCaChanName(subs[1])
-			if err != nil {
-				return nil, err
-			}
-			channels[caString] += c.Col[0][rel.eventIter]
+			channels[keys["channel"]] += c.Col[0][rel.eventIter]
 		}
 	}
 
 	return channels, nil
 }
 
-// extractCaChanName attempts to extract the name of the calcium channel based
-// on the expected data name pattern <ca naming>.<seed>.dat
-func extractCaChanName(name string) (string, error) {
-	items := strings.Split(name, ".")
-	if len(items) == 0 {
-		return "", fmt.Errorf("Could not determine Ca channel name from data set %s", name)
-	}
-	return items[0], nil
-}
-
 // createAnalysisJobs fills a channel with binary data filenames to be analyzed
 func createAnalysisJobs(fileNames []string, analysisJobs chan<- string) {
 	for _, n := range fileNames {
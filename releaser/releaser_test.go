@@ -0,0 +1,110 @@
+package releaser
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/haskelladdict/mbdr/libmbd"
+)
+
+// oldCaChanContrib determines the Ca channel contributions like
+// determineCaChanContrib did before caPattern was introduced
+func oldCaChanContrib(data *libmbd.MCellData, rel *ReleaseEvent) (map[string]float64,
+	error) {
+
+	channels := make(map[string]float64)
+	regexString := fmt.Sprintf("vesicle(_Y)?_%s_ca_.*", rel.vesicleID)
+	counts, err := data.BlockDataByRegex(regexString)
+	if err != nil {
+		return nil, err
+	}
+	for k, c := range counts {
+		if c.Col[0][rel.eventIter] > 0 {
+			subs := strings.SplitAfter(k, "ca_")
+			channels[strings.Split(subs[1], ".")[0]] += c.Col[0][rel.eventIter]
+		}
+	}
+	return channels, nil
+}
+
+// TestCaPattern checks that caPattern extracts the same vesicles and Ca
+// channels as the name parsing it replaced for the names of the Ca binding
+// data of the frog and mouse models
+func TestCaPattern(t *testing.T) {
+	names := []string{
+		"vesicle_01_ca_A01.0001.dat",
+		"vesicle_Y_01_ca_A01.0001.dat",
+		"vesicle_01_ca_A04_2.0001.dat",
+		"vesicle_02_ca_A01.0001.dat",
+		"vesicle_1_1_ca_pq_1.0012.dat",
+		"vesicle_Y_1_1_ca_pq_1.0012.dat",
+		"vesicle_1_1_ca_pq_2.0012.dat",
+		"vesicle_1_2_ca_pq_1.0012.dat",
+		"vesicle_2_1_ca_pq_12.0012.dat",
+		"bound_vesicle_01_sensor_01.0001.dat",
+	}
+	var blocks []libmbd.DataBlock
+	for i, n := range names {
+		blocks = append(blocks, libmbd.DataBlock{Name: n, Data: &libmbd.CountData{
+			Col: [][]float64{{float64(i + 1)}}, DataTypes: []uint16{libmbd.IntType}}})
+	}
+	data, err := libmbd.NewMCellData(blocks, libmbd.TimeSpec{OutputListType: libmbd.Step,
+		StepSize: 1e-6})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, ves := range []string{"01", "02", "03", "1_1", "1_2", "2_1"} {
+		rel := &ReleaseEvent{vesicleID: ves}
+		want, err := oldCaChanContrib(data, rel)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := determineCaChanContrib(data, rel)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(want) == 0 && ves != "03" {
+			t.Errorf("vesicle %s: no Ca channels found", ves)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("vesicle %s: got Ca channels %v, want %v", ves, got, want)
+		}
+	}
+}
+
+// TestTemplateRegex checks that the regular expressions derived from the
+// sensor templates of the analyzers match exactly the names the templates
+// produce
+func TestTemplateRegex(t *testing.T) {
+	tests := []struct {
+		template string
+		args     []interface{}
+		other    []string
+	}{
+		{"bound_vesicle_%s_%s_%02d.%04d.dat", []interface{}{"01", "sensor_Y", 3, 12},
+			[]string{"bound_vesicle_01_sensor_Y_03.0012.dat.bak",
+				"vesicle_01_ca_A01.0012.dat", "bound_vesicle_01_sensor_xx.0012.dat"}},
+		{"bound_vesicle_%s_%s_%02d_%d.%04d.dat", []interface{}{"01", "sensor", 12, 2, 1},
+			[]string{"bound_vesicle_01_sensor_12.0001.dat"}},
+		{"bound_vesicle_%s_%s_%d.%04d.dat", []interface{}{"1_1", "sensor", 7, 12345},
+			[]string{"bound_vesicle_1_1_sensor_7.dat"}},
+		{"bound_vesicle_%s_%s_%d_%d.%04d.dat", []interface{}{"2_2", "sensor_Y", 1, 10, 3},
+			[]string{"bound_vesicle_2_2_sensor_Y_1.0003.dat"}},
+		{"100%%_%s", []interface{}{"bound"}, []string{"100%%_bound", "100_bound"}},
+	}
+	for _, tt := range tests {
+		regex := regexp.MustCompile(templateRegex(tt.template))
+		if n := fmt.Sprintf(tt.template, tt.args...); !regex.MatchString(n) {
+			t.Errorf("%s does not match template %s", n, tt.template)
+		}
+		for _, n := range tt.other {
+			if regex.MatchString(n) {
+				t.Errorf("%s matches template %s", n, tt.template)
+			}
+		}
+	}
+}